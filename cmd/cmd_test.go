@@ -3,6 +3,7 @@ package cmd
 import (
 	"io"
 	"testing"
+	"time"
 
 	log "github.com/spf13/jwalterweatherman"
 
@@ -47,11 +48,25 @@ func setup(t *testing.T) {
 
 		// from compare
 		ext2 = "_current"
+		format = "text"
+		detectMoves = false
+		hardLinks = false
+
+		// from dupes
+		dupesFormat = "text"
 
 		// from update
 		fast = false
 		autosave = false
 		overwrite = false
+		workers = 0
+		resume = false
+		checkpointInterval = 5 * time.Minute
+		showProgress = true
+		maxReadRate = ""
+		maxFileRate = 0
+		scrub = ""
+		scrubBytes = ""
 	})
 }
 
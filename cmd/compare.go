@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/spf13/cobra"
 	log "github.com/spf13/jwalterweatherman"
@@ -13,11 +14,17 @@ import (
 
 var ext2 string
 var ignoreMissing bool
+var format string
+var detectMoves bool
+var hardLinks bool
 
 func init() {
 	// default to _current to compare current values of 2 indexes (i.e. 2 filesystems)
 	compareCmd.Flags().StringVar(&ext2, "ext2", "_current", "extension for the second index")
 	compareCmd.Flags().BoolVar(&ignoreMissing, "ignore_missing", false, "ignore missing files in the _first_ index")
+	compareCmd.Flags().StringVar(&format, "format", "text", "output format for differences: text, json or csv")
+	compareCmd.Flags().BoolVar(&detectMoves, "detect-moves", false, "report removed & added files with the same contents as moved")
+	compareCmd.Flags().BoolVar(&hardLinks, "hard-links", false, "report files that are hard linked to different files")
 }
 
 var compareCmd = &cobra.Command{
@@ -28,7 +35,11 @@ var compareCmd = &cobra.Command{
 }
 
 func runCompare(cmd *cobra.Command, args []string) error {
-	cfg, err := config.Load(args[0])
+	if err := checkFormat(); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(args[0])
 
 	if err != nil {
 		return err
@@ -46,7 +57,7 @@ func runCompare(cmd *cobra.Command, args []string) error {
 
 	// one arg => use the same config
 	if len(args) > 1 {
-		otherCfg, err = config.Load(args[1])
+		otherCfg, err = loadConfig(args[1])
 
 		if err != nil {
 			return err
@@ -63,14 +74,64 @@ func runCompare(cmd *cobra.Command, args []string) error {
 
 	log.INFO.Println()
 
-	same := util.Compare(newIdx, oldIdx, ignoreMissing)
+	result, err := util.CompareIndexes(newIdx, oldIdx, ignoreMissing)
+
+	if err != nil {
+		return err
+	}
+
+	if err = outputResult(result, newIdx, oldIdx); err != nil {
+		return err
+	}
 
-	if !same {
+	if !result.Same() {
 		// empty error message => no error logged in main()
 		// but _will_ trigger an exit code of 1
 		return errors.New("")
 	}
 
-	log.INFO.Println("no differences!")
+	if result.Counts.MetadataChanged() {
+		log.INFO.Println("no differences in file contents; only metadata changed")
+	} else {
+		log.INFO.Println("no differences!")
+	}
+
 	return nil
 }
+
+// checkFormat validates the --format flag.
+// Machine readable formats also reset logging so the result is the only output.
+func checkFormat() error {
+	switch format {
+	case "text":
+		return nil
+	case "json", "csv":
+		log.SetLogThreshold(log.LevelWarn)
+		log.SetStdoutThreshold(log.LevelError)
+		return nil
+	default:
+		return fmt.Errorf("unknown format '%s'; must be text, json or csv", format)
+	}
+}
+
+// outputResult writes the comparison of the given Indexes in the format given by the --format flag.
+// Moves and hard link changes are detected first if --detect-moves or --hard-links are set.
+func outputResult(result *util.CompareResult, one *index.Index, two *index.Index) error {
+	if detectMoves {
+		result.DetectMoves()
+	}
+
+	if hardLinks {
+		result.DetectHardLinks(one, two)
+	}
+
+	switch format {
+	case "json":
+		return result.WriteJSON(writer)
+	case "csv":
+		return result.WriteCSV(writer)
+	default:
+		result.Log()
+		return nil
+	}
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/file"
 	"github.com/hpresnall/yabrc/test"
+	"github.com/hpresnall/yabrc/util"
 )
 
 func TestCompareSelf(t *testing.T) {
@@ -78,3 +82,92 @@ func TestCompareBadConfig(t *testing.T) {
 		t.Error("should error on invalid config", err)
 	}
 }
+
+func TestCompareDifferentHashes(t *testing.T) {
+	setup(t)
+
+	cfg.SetHash("sha512")
+
+	other, err := util.BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	ext2 = "_sha512"
+	other.Store(ext2)
+
+	err = runCompare(nil, args)
+
+	if (err == nil) || (err.Error() == "") {
+		t.Error("should error with a message when comparing different hash algorithms", err)
+	}
+}
+
+func TestCompareFormats(t *testing.T) {
+	for _, f := range []string{"json", "csv"} {
+		setup(t)
+
+		path := cfg.Root() + "/zzz"
+		info := test.MakeFile(t, path, "zzz", 0644)
+		idx.Add(path, info)
+
+		ext2 = "_different"
+		idx.Store(ext2)
+
+		var buffer bytes.Buffer
+		writer = &buffer
+		format = f
+
+		err := runCompare(nil, args)
+
+		if (err == nil) || (err.Error() != "") {
+			t.Error("should error with empty Error when different", err)
+		}
+
+		if !strings.Contains(buffer.String(), "zzz") {
+			t.Errorf("%s output should contain the difference: %s", f, buffer.String())
+		}
+	}
+}
+
+func TestCompareBadFormat(t *testing.T) {
+	setup(t)
+
+	format = "xml"
+
+	if err := runCompare(nil, args); err == nil {
+		t.Error("should error on unknown format")
+	}
+}
+
+func TestCompareDetectMoves(t *testing.T) {
+	setup(t)
+
+	// rename a file in the second index
+	path := cfg.Root() + "/test3/test3"
+	moved := cfg.Root() + "/test3/moved"
+	file.GetFs().Rename(path, moved)
+
+	other, err := util.BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	ext2 = "_moved"
+	other.Store(ext2)
+
+	var buffer bytes.Buffer
+	writer = &buffer
+	format = "csv"
+	detectMoves = true
+
+	if err := runCompare(nil, args); (err == nil) || (err.Error() != "") {
+		t.Error("should error with empty Error when different", err)
+	}
+
+	if !strings.Contains(buffer.String(), "test3/test3,moved,") {
+		t.Error("output should contain the moved file", buffer.String())
+	}
+}
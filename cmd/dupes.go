@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	log "github.com/spf13/jwalterweatherman"
+
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/util"
+)
+
+var dupesFormat string
+
+func init() {
+	dupesCmd.Flags().StringVar(&dupesFormat, "format", "text", "output format: text, json or sh; sh outputs a script of suggested removals")
+}
+
+var dupesCmd = &cobra.Command{
+	Use:   "dupes <config_file> [config_file...]",
+	Short: "Find duplicate files across one or more indexes",
+	Args:  cobra.MinimumNArgs(1), // at least one config file
+	RunE:  runDupes,
+}
+
+func runDupes(_ *cobra.Command, args []string) error {
+	switch dupesFormat {
+	case "text":
+	case "json", "sh":
+		// reset log so the output is the only output
+		log.SetLogThreshold(log.LevelWarn)
+		log.SetStdoutThreshold(log.LevelError)
+	default:
+		return fmt.Errorf("unknown format '%s'; must be text, json or sh", dupesFormat)
+	}
+
+	indexes := make([]*index.Index, len(args))
+
+	for n, configFile := range args {
+		config, err := loadConfig(configFile)
+
+		if err != nil {
+			return err
+		}
+
+		indexes[n], err = index.Load(&config, ext)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	log.INFO.Println()
+
+	groups, err := util.FindDuplicates(indexes...)
+
+	if err != nil {
+		return err
+	}
+
+	switch dupesFormat {
+	case "json":
+		return util.WriteDuplicatesJSON(writer, groups)
+	case "sh":
+		return util.WriteDuplicatesScript(writer, groups)
+	default:
+		util.LogDuplicates(groups)
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestDupes(t *testing.T) {
+	setup(t)
+
+	if err := runDupes(nil, args); err != nil {
+		t.Error("should not error on dupes", err)
+	}
+}
+
+func TestDupesFormats(t *testing.T) {
+	for _, outputFormat := range []string{"json", "sh"} {
+		setup(t)
+
+		path := cfg.Root() + "/copy"
+		f := test.MakeFile(t, path, "data3", 0644) // same as test3/test3
+		idx.Add(path, f)
+		idx.Store(ext)
+
+		var buffer bytes.Buffer
+		writer = &buffer
+		dupesFormat = outputFormat
+
+		if err := runDupes(nil, args); err != nil {
+			t.Error("should not error on dupes", err)
+		}
+
+		if !strings.Contains(buffer.String(), "test3/test3") {
+			t.Errorf("%s output should contain the duplicate: %s", outputFormat, buffer.String())
+		}
+	}
+}
+
+func TestDupesBadFormat(t *testing.T) {
+	setup(t)
+
+	dupesFormat = "xml"
+
+	if err := runDupes(nil, args); err == nil {
+		t.Error("should error on unknown format")
+	}
+}
+
+func TestDupesBadConfig(t *testing.T) {
+	setup(t)
+
+	if err := runDupes(nil, []string{config.TestFile, "invalid"}); err == nil {
+		t.Error("should error on invalid config")
+	}
+}
+
+func TestDupesBadIndex(t *testing.T) {
+	setup(t)
+
+	if err := file.GetFs().Remove(idx.GetFile(ext)); err != nil {
+		t.Fatalf("cannot remove index from file system")
+	}
+
+	if err := runDupes(nil, args); err == nil {
+		t.Error("should error on missing index")
+	}
+}
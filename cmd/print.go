@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/index"
 	"github.com/spf13/cobra"
 	log "github.com/spf13/jwalterweatherman"
@@ -41,7 +40,7 @@ func runPrint(_ *cobra.Command, args []string) error {
 	}
 
 	for n, configFile := range args {
-		config, err := config.Load(configFile)
+		config, err := loadConfig(configFile)
 
 		if err != nil {
 			return err
@@ -1,12 +1,15 @@
 package cmd
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
 	log "github.com/spf13/jwalterweatherman"
 
 	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/test"
 )
 
 func TestPrint(t *testing.T) {
@@ -49,6 +52,22 @@ func TestPrintJson(t *testing.T) {
 	}
 }
 
+func TestPrintJsonVerified(t *testing.T) {
+	setup(t)
+
+	var buffer bytes.Buffer
+	writer = &buffer
+	json = true
+
+	if err := runPrint(nil, args); err != nil {
+		t.Fatal("should not error on JSON print", err)
+	}
+
+	if !strings.Contains(buffer.String(), "\"verified\": ") {
+		t.Error("JSON should include verified time", buffer.String())
+	}
+}
+
 func TestPrintEntries(t *testing.T) {
 	setup(t)
 
@@ -80,3 +99,17 @@ func TestPrintBadIndex(t *testing.T) {
 		t.Error("should error on invalid config", err)
 	}
 }
+
+func TestPrintUnknownHash(t *testing.T) {
+	setup(t)
+
+	test.MakeFile(t, config.TestFile, "root: "+cfg.Root()+"\nbaseName: "+cfg.BaseName()+"\nsavePath: "+cfg.SavePath()+"\nhash: md5", 0644)
+
+	for _, cmd := range []string{"print", "compare", "verify", "dupes"} {
+		rootCmd.SetArgs([]string{cmd, config.TestFile})
+
+		if err := rootCmd.Execute(); (err == nil) || !strings.Contains(err.Error(), "md5") {
+			t.Errorf("%s should error on unknown hash: %v", cmd, err)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package cmd
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	golog "log"
 	"os"
 
 	"github.com/spf13/cobra"
 	log "github.com/spf13/jwalterweatherman"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/index"
 )
 
 var version = "test"
@@ -23,7 +27,10 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "DEBUG level logging")
 	rootCmd.PersistentFlags().StringVarP(&ext, "ext", "e", "_current", "index file extension")
 
-	rootCmd.AddCommand(versionCmd, printCmd, updateCmd, compareCmd)
+	rootCmd.AddCommand(versionCmd, printCmd, updateCmd, compareCmd, verifyCmd, dupesCmd)
+
+	// record the version in all new indexes
+	index.Version = version
 }
 
 var rootCmd = &cobra.Command{
@@ -51,6 +58,22 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// loadConfig loads the given config file and validates the values that are only checked when an Index is created,
+// so errors are reported before any files are read.
+func loadConfig(configFile string) (config.Config, error) {
+	cfg, err := config.Load(configFile)
+
+	if err != nil {
+		return cfg, err
+	}
+
+	if _, err = index.GetHasher(cfg.Hash()); err != nil {
+		return cfg, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	return cfg, nil
+}
+
 // Execute runs the command line application.
 func Execute() error {
 	return rootCmd.Execute()
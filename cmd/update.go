@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
@@ -18,12 +25,34 @@ var fast bool
 var autosave bool
 var overwrite bool
 var oldExt string
+var workers int
+var resume bool
+var checkpointInterval time.Duration
+var showProgress bool
+var maxReadRate string
+var maxFileRate int
+var scrub string
+var scrubBytes string
+
+// suffix added to the index extension for the partial index stored while updating
+const checkpointSuffix = "_partial"
 
 func init() {
 	updateCmd.Flags().BoolVarP(&fast, "fast", "f", false, "only hash new or updated files")
 	updateCmd.Flags().BoolVarP(&autosave, "autosave", "a", false, "save the updated index without user confirmation")
 	updateCmd.Flags().BoolVarP(&overwrite, "overwrite", "o", false, "overwrite the existing index")
 	updateCmd.Flags().StringVar(&oldExt, "old_ext", "", "extension for storing the old Index; ignored with --overwrite; defaults to timestamp")
+	updateCmd.Flags().IntVarP(&workers, "workers", "w", 0, "number of files to hash in parallel; overrides the config's workers value")
+	updateCmd.Flags().BoolVar(&detectMoves, "detect-moves", false, "report removed & added files with the same contents as moved")
+	updateCmd.Flags().BoolVar(&hardLinks, "hard-links", false, "report files that are hard linked to different files")
+	updateCmd.Flags().StringVar(&format, "format", "text", "output format for differences: text, json or csv; json and csv require --autosave")
+	updateCmd.Flags().BoolVar(&resume, "resume", false, "continue an interrupted update, reusing files hashed before the last checkpoint")
+	updateCmd.Flags().StringVar(&maxReadRate, "max-read-rate", "", "maximum rate to read files, e.g. 50MB/s; overrides the config's maxReadRate value")
+	updateCmd.Flags().IntVar(&maxFileRate, "max-file-rate", 0, "maximum number of files to hash per second; overrides the config's maxFileRate value")
+	updateCmd.Flags().StringVar(&scrub, "scrub", "", "percentage of unchanged bytes to rehash, e.g. 10%; least recently verified first; implies --fast")
+	updateCmd.Flags().StringVar(&scrubBytes, "scrub-bytes", "", "amount of unchanged data to rehash, e.g. 500GB; least recently verified first; implies --fast")
+	updateCmd.Flags().BoolVar(&showProgress, "progress", true, "periodically output the number of files hashed and the estimated time remaining")
+	updateCmd.Flags().DurationVar(&checkpointInterval, "checkpoint-interval", util.DefaultCheckpointInterval, "how often to store a checkpoint of a partial update; 0 disables checkpoints")
 }
 
 var updateCmd = &cobra.Command{
@@ -34,12 +63,31 @@ var updateCmd = &cobra.Command{
 }
 
 func runUpdate(cmd *cobra.Command, args []string) error {
-	config, err := config.Load(args[0])
+	// prompts would be mixed into the output
+	if (format != "text") && !autosave {
+		return fmt.Errorf("--format %s requires --autosave", format)
+	}
+
+	if err := checkFormat(); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(args[0])
 
 	if err != nil {
 		return err
 	}
 
+	if err = setScanFlags(&config); err != nil {
+		return err
+	}
+
+	options := util.BuildOptions{Resume: resume}
+
+	if err = setScrub(&options); err != nil {
+		return err
+	}
+
 	indexFile := index.GetIndexFile(&config, ext)
 
 	log.INFO.Println()
@@ -60,33 +108,98 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 
 	log.INFO.Println()
 
-	var newIdx *index.Index
-
 	if fast {
-		newIdx, err = util.BuildIndex(&config, existingIdx)
-	} else {
-		newIdx, err = util.BuildIndex(&config, nil)
+		options.Existing = existingIdx
+	}
+
+	if checkpointInterval > 0 {
+		// separate checkpoints for each index built from the same config
+		options.CheckpointExt = ext + checkpointSuffix
+		options.CheckpointInterval = checkpointInterval
+	} else if resume {
+		log.WARN.Println("ignoring --resume with checkpoints disabled")
+	}
+
+	if showProgress {
+		setProgress(&options, existingIdx)
+	}
+
+	// Ctrl-C stops the build after storing a final checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	options.Context = ctx
+
+	go func() {
+		<-ctx.Done()
+
+		// a second Ctrl-C exits immediately
+		stop()
+	}()
+
+	newIdx, err := util.BuildIndexWithOptions(&config, options)
+
+	// restore default handling for prompts
+	stop()
+
+	if errors.Is(err, util.ErrInterrupted) && (options.CheckpointExt != "") {
+		return fmt.Errorf("%v; run update with --resume to continue", err)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	if existingIdx != nil {
+	// only move the old index when the contents changed
+	rotate := !overwrite && (existingIdx != nil)
+
+	if existingIdx == nil {
+		if err = outputSkipped(newIdx, nil, "no existing index"); err != nil {
+			return err
+		}
+	} else {
 		log.INFO.Println()
-		log.INFO.Printf("comparing '%s' %s vs %s\n", newIdx.Config().Root(), humanize.Time(newIdx.Timestamp()), humanize.Time(existingIdx.Timestamp()))
-		same := util.Compare(newIdx, existingIdx, false)
 
-		if same {
-			log.INFO.Println("Indexes are the same")
-			return nil
+		if existingIdx.Hasher() != newIdx.Hasher() {
+			// hashes will never match; the new index will replace the existing one
+			reason := fmt.Sprintf("hash algorithm changed from '%s' to '%s'", existingIdx.Hasher(), newIdx.Hasher())
+			log.WARN.Printf("%s; skipping comparison\n", reason)
+
+			if err = outputSkipped(newIdx, existingIdx, reason); err != nil {
+				return err
+			}
+		} else {
+			log.INFO.Printf("comparing '%s' %s vs %s\n", newIdx.Config().Root(), humanize.Time(newIdx.Timestamp()), humanize.Time(existingIdx.Timestamp()))
+			result, err := util.CompareIndexes(newIdx, existingIdx, false)
+
+			if err != nil {
+				return err
+			}
+
+			if err = outputResult(result, newIdx, existingIdx); err != nil {
+				return err
+			}
+
+			if result.Same() {
+				if result.Counts.MetadataChanged() {
+					log.INFO.Println("Indexes have the same file contents; only metadata changed")
+				} else {
+					log.INFO.Println("Indexes are the same")
+				}
+
+				if !result.Counts.MetadataChanged() && !recordsChanged(newIdx, existingIdx) {
+					return nil
+				}
+
+				// keep the new verification times and file status without keeping an identical copy of the old index
+				log.INFO.Println("updating recorded file metadata")
+				rotate = false
+			}
 		}
 	}
 
 	log.INFO.Println()
 
 	// move old index to file with a different extension
-	if !overwrite && (existingIdx != nil) {
+	if rotate {
 		if oldExt == "" {
 			oldExt = "_" + existingIdx.Timestamp().Format("20060102_150405")
 		}
@@ -109,7 +222,7 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 
 	// save the new index, possibly to the same file name
 	if autosave {
-		if overwrite && (existingIdx != nil) {
+		if !rotate && (existingIdx != nil) {
 			log.INFO.Printf("overwriting Index '%s'\n", indexFile)
 		} else {
 			log.INFO.Printf("saving Index to '%s'\n", indexFile)
@@ -117,7 +230,7 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 	} else {
 		var prompt string
 
-		if overwrite && (existingIdx != nil) {
+		if !rotate && (existingIdx != nil) {
 			prompt = fmt.Sprintf("overwrite Index '%s'", indexFile)
 		} else {
 			prompt = fmt.Sprintf("save Index to '%s'", indexFile)
@@ -137,6 +250,111 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// recordsChanged returns true if any Entry in the new Index was verified at a different time than in the existing
+// Index, e.g. when it was rehashed by a scrub, has a different status change time or inode, e.g. after a chmod, or
+// has permissions or extended attributes that were not recorded in the existing Index.
+func recordsChanged(newIdx *index.Index, existingIdx *index.Index) bool {
+	changed := false
+
+	newIdx.ForEach(func(e index.Entry) {
+		if changed {
+			return
+		}
+
+		existing, exists := existingIdx.Get(e.Path())
+
+		if !exists {
+			return
+		}
+
+		ctime, inode, _ := e.Status()
+		existingCtime, existingInode, _ := existing.Status()
+
+		perms, hasPerms := e.Permissions()
+		existingPerms, existingHasPerms := existing.Permissions()
+		xattrs, _ := e.Xattrs()
+		existingXattrs, _ := existing.Xattrs()
+
+		// times are stored in seconds
+		changed = !e.Verified().Equal(existing.Verified()) || (ctime.Unix() != existingCtime.Unix()) || (inode != existingInode) ||
+			(hasPerms != existingHasPerms) || (perms != existingPerms) || (xattrs != existingXattrs) ||
+			!slices.Equal(e.XattrNames(), existing.XattrNames())
+	})
+
+	return changed
+}
+
+// setScanFlags overrides the Config with any flags that control how files are scanned.
+func setScanFlags(config *config.Config) error {
+	if workers != 0 {
+		if err := config.SetWorkers(workers); err != nil {
+			return err
+		}
+	}
+
+	if maxReadRate != "" {
+		if err := config.SetMaxReadRate(maxReadRate); err != nil {
+			return err
+		}
+	}
+
+	if maxFileRate != 0 {
+		if err := config.SetMaxFileRate(maxFileRate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setScrub parses the scrub flags. Scrubbing requires reusing the existing index, so it also sets fast.
+func setScrub(options *util.BuildOptions) error {
+	if scrub != "" {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(scrub), "%"), 64)
+
+		if (err != nil) || (percent < 0) || (percent > 100) {
+			return fmt.Errorf("--scrub must be a percentage from 0 to 100, not '%s'", scrub)
+		}
+
+		options.ScrubFraction = percent / 100
+	}
+
+	if scrubBytes != "" {
+		bytes, err := humanize.ParseBytes(scrubBytes)
+
+		if err != nil {
+			return fmt.Errorf("invalid --scrub-bytes: %v", err)
+		}
+
+		options.ScrubBytes = int64(bytes)
+	}
+
+	if ((options.ScrubFraction > 0) || (options.ScrubBytes > 0)) && !fast {
+		log.DEBUG.Println("--scrub implies --fast")
+		fast = true
+	}
+
+	return nil
+}
+
+// setProgress outputs a refreshing line on a terminal, otherwise periodic log lines.
+// The existing index, if any, is used to estimate the time remaining.
+func setProgress(options *util.BuildOptions, existingIdx *index.Index) {
+	if util.IsTerminal(os.Stderr) {
+		options.Progress = util.NewTerminalProgress(os.Stderr)
+		options.ProgressInterval = time.Second
+	} else {
+		options.Progress = util.LogProgress{}
+		options.ProgressInterval = time.Minute
+	}
+
+	if existingIdx != nil {
+		existingIdx.ForEach(func(e index.Entry) {
+			options.ExpectedBytes += e.Size()
+		})
+	}
+}
+
 func confirm(prompt string) bool {
 	for {
 		fmt.Fprintf(writer, "%s? (y/n) ", prompt)
@@ -158,3 +376,18 @@ func confirm(prompt string) bool {
 		}
 	}
 }
+
+// outputSkipped writes a result without differences for indexes that cannot be compared, so json and csv output is
+// always valid. Text output only includes the logged warnings. existingIdx may be nil.
+func outputSkipped(newIdx *index.Index, existingIdx *index.Index, reason string) error {
+	result := util.SkippedResult(newIdx, existingIdx, reason)
+
+	switch format {
+	case "json":
+		return result.WriteJSON(writer)
+	case "csv":
+		return result.WriteCSV(writer)
+	default:
+		return nil
+	}
+}
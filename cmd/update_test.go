@@ -2,12 +2,25 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	encjson "encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/spf13/afero"
+
+	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/index"
 	"github.com/hpresnall/yabrc/test"
+	"github.com/hpresnall/yabrc/util"
 )
 
 var idxTime time.Time
@@ -82,6 +95,374 @@ func TestUpdateFast(t *testing.T) {
 	oldExists(t)
 }
 
+func TestUpdateWorkers(t *testing.T) {
+	setupUpdate(t)
+
+	workers = 2
+	overwrite = true
+	autosave = true
+
+	runAndValidate(t)
+	currentUpdated(t)
+}
+
+func TestUpdateInvalidWorkers(t *testing.T) {
+	setupUpdate(t)
+
+	workers = -1
+
+	if err := runUpdate(nil, args); err == nil {
+		t.Error("should error with negative workers")
+	}
+}
+
+func TestUpdateRates(t *testing.T) {
+	setupUpdate(t)
+
+	maxReadRate = "1MB/s"
+	maxFileRate = 100
+	overwrite = true
+	autosave = true
+
+	runAndValidate(t)
+	currentUpdated(t)
+}
+
+func TestUpdateInvalidRates(t *testing.T) {
+	setupUpdate(t)
+
+	maxReadRate = "fast"
+
+	if err := runUpdate(nil, args); err == nil {
+		t.Error("should error with invalid read rate")
+	}
+
+	maxReadRate = ""
+	maxFileRate = -1
+
+	if err := runUpdate(nil, args); err == nil {
+		t.Error("should error with negative file rate")
+	}
+}
+
+func TestUpdateScrub(t *testing.T) {
+	for _, flags := range [][]string{{"10%", ""}, {"", "1KB"}, {"50", "1"}} {
+		setupUpdate(t)
+
+		scrub = flags[0]
+		scrubBytes = flags[1]
+		overwrite = true
+		autosave = true
+
+		runAndValidate(t)
+		currentUpdated(t)
+
+		if !fast {
+			t.Error("scrub should imply fast")
+		}
+
+		fast = false
+	}
+}
+
+func TestUpdateScrubTwice(t *testing.T) {
+	setup(t) // no changes; scrubbing only updates verification times
+	ageVerified(t)
+
+	scrubBytes = "1"
+	autosave = true
+
+	runAndValidate(t)
+	first := recentlyVerified(t)
+
+	runAndValidate(t)
+	second := recentlyVerified(t)
+
+	if (len(first) != 1) || (len(second) != 2) {
+		t.Fatalf("each scrub should verify one more file; first: %v, second: %v", first, second)
+	}
+
+	if second[0] == second[1] {
+		t.Error("second scrub should choose a different file", second)
+	}
+
+	// nothing changed, so the old index should be overwritten, not moved
+	if files, _ := afero.ReadDir(file.GetFs(), cfg.SavePath()); len(files) != 1 {
+		t.Error("only the current index should be stored, not", len(files))
+	}
+}
+
+func TestUpdateFastStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("status change times are only recorded on Unix")
+	}
+
+	setup(t)
+	root := test.SetupOsFs(t)
+
+	test.MakeDir(t, root+"/data")
+	info := test.MakeFile(t, root+"/data/test", "data", 0644)
+	test.MakeFile(t, root+"/config.yaml", "root: "+root+"/data\nbaseName: test\nsavePath: "+root, 0644)
+
+	args = []string{root + "/config.yaml"}
+	autosave = true
+	overwrite = true
+
+	runAndValidate(t)
+
+	// replace the file with the same contents and modification time, like rsync -t
+	test.MakeFile(t, root+"/replacement", "data", 0644)
+	file.GetFs().Chtimes(root+"/replacement", info.ModTime(), info.ModTime())
+
+	if err := file.GetFs().Rename(root+"/replacement", root+"/data/test"); err != nil {
+		t.Fatal("cannot replace file", err)
+	}
+
+	// the contents are the same, but the new status should still be stored
+	fast = true
+	runAndValidate(t)
+
+	cfg, err := loadConfig(args[0])
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	updated, err := index.Load(&cfg, ext)
+
+	if err != nil {
+		t.Fatal("should be able to load updated index", err)
+	}
+
+	current, err := util.BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	e, _ := updated.Get("test")
+	expected, _ := current.Get("test")
+
+	ctime, inode, _ := e.Status()
+	expectedCtime, expectedInode, _ := expected.Status()
+
+	// stored in seconds
+	if (ctime.Unix() != expectedCtime.Unix()) || (inode != expectedInode) {
+		t.Error("update should store the replaced file's status", e.String(), expected.String())
+	}
+
+	// nothing changed, so the index should not be stored again
+	indexFile := updated.GetFile(ext)
+	stored := time.Unix(1234, 0)
+	file.GetFs().Chtimes(indexFile, stored, stored)
+
+	runAndValidate(t)
+
+	if info, _ := file.GetFs().Stat(indexFile); !info.ModTime().Equal(stored) {
+		t.Error("unchanged index should not be stored")
+	}
+}
+
+func TestUpdateRecordPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are only recorded on Unix")
+	}
+
+	setup(t)
+	root := test.SetupOsFs(t)
+	configFile := root + "/config.yaml"
+	configString := "root: " + root + "/data\nbaseName: test\nsavePath: " + root
+
+	test.MakeDir(t, root+"/data")
+	test.MakeFile(t, root+"/data/test", "data", 0644)
+	test.MakeFile(t, configFile, configString, 0644)
+
+	args = []string{configFile}
+	autosave = true
+	overwrite = true
+	fast = true
+
+	runAndValidate(t)
+
+	// enabling permissions and then changing them only changes metadata; both should be stored
+	for _, mode := range []os.FileMode{0644, 0600} {
+		test.MakeFile(t, configFile, configString+"\nrecordPermissions: true", 0644)
+
+		if err := os.Chmod(root+"/data/test", mode); err != nil {
+			t.Fatal("cannot chmod file", err)
+		}
+
+		runAndValidate(t)
+
+		cfg, err := loadConfig(configFile)
+
+		if err != nil {
+			t.Fatal("cannot load config", err)
+		}
+
+		updated, err := index.Load(&cfg, ext)
+
+		if err != nil {
+			t.Fatal("should be able to load updated index", err)
+		}
+
+		e, _ := updated.Get("test")
+
+		if p, exists := e.Permissions(); !exists || (p.Mode.Perm() != mode) {
+			t.Errorf("update should store mode %v; %s", mode, e.String())
+		}
+	}
+}
+
+func TestUpdateInvalidScrub(t *testing.T) {
+	for _, flags := range [][]string{{"x", ""}, {"101%", ""}, {"-1", ""}, {"", "big"}} {
+		setupUpdate(t)
+
+		scrub = flags[0]
+		scrubBytes = flags[1]
+
+		if err := runUpdate(nil, args); err == nil {
+			t.Errorf("should error with invalid scrub %q", flags)
+		}
+	}
+}
+
+func TestUpdateResume(t *testing.T) {
+	setupUpdate(t)
+
+	// checkpoint includes the new file
+	idx.SetPosition("another")
+	idx.Store(ext + checkpointSuffix)
+
+	resume = true
+	overwrite = true
+	autosave = true
+
+	runAndValidate(t)
+	currentUpdated(t)
+
+	if _, err := file.GetFs().Stat(idx.GetFile(ext + checkpointSuffix)); err == nil {
+		t.Error("checkpoint should be removed after update")
+	}
+}
+
+func TestUpdateResumeNoCheckpoints(t *testing.T) {
+	setupUpdate(t)
+
+	resume = true
+	checkpointInterval = 0
+	overwrite = true
+	autosave = true
+
+	runAndValidate(t)
+	currentUpdated(t)
+}
+
+func TestUpdateChangedHash(t *testing.T) {
+	setup(t) // do not add file
+
+	// index should be saved, even though no files were changed, since the hashes changed
+	test.MakeFile(t, config.TestFile, "root: testRoot\nbaseName: testBaseName\nsavePath: testSavePath\nhash: sha512", 0644)
+
+	overwrite = true
+	autosave = true
+
+	runAndValidate(t)
+
+	updated, err := index.Load(&cfg, ext)
+
+	if err != nil {
+		t.Fatal("should be able to load updated index", err)
+	}
+
+	if updated.Hasher() != index.SHA512 {
+		t.Error("updated index should use sha512, not", updated.Hasher())
+	}
+}
+
+func TestUpdateJSON(t *testing.T) {
+	setupUpdate(t)
+
+	var buffer bytes.Buffer
+	writer = &buffer
+	format = "json"
+	autosave = true
+
+	runAndValidate(t)
+	currentUpdated(t)
+
+	if !strings.Contains(buffer.String(), "\"another\"") {
+		t.Error("JSON output should contain the new file", buffer.String())
+	}
+}
+
+func TestUpdateJSONNew(t *testing.T) {
+	setupUpdate(t)
+
+	var buffer bytes.Buffer
+	writer = &buffer
+	format = "json"
+	autosave = true
+
+	file.GetFs().Remove(idx.GetFile(ext))
+
+	runAndValidate(t)
+	currentUpdated(t)
+
+	// nothing to compare, but the output must still be valid
+	var parsed struct {
+		Two     *struct{} `json:"two"`
+		Skipped string    `json:"skipped"`
+	}
+
+	if err := encjson.Unmarshal(buffer.Bytes(), &parsed); err != nil {
+		t.Fatal("should output valid JSON", err, buffer.String())
+	}
+
+	if (parsed.Two != nil) || (parsed.Skipped != "no existing index") {
+		t.Error("JSON output should explain why there are no differences", buffer.String())
+	}
+}
+
+func TestUpdateCSVChangedHash(t *testing.T) {
+	setup(t)
+
+	test.MakeFile(t, config.TestFile, "root: testRoot\nbaseName: testBaseName\nsavePath: testSavePath\nhash: sha512", 0644)
+
+	var buffer bytes.Buffer
+	writer = &buffer
+	format = "csv"
+	overwrite = true
+	autosave = true
+
+	runAndValidate(t)
+
+	if buffer.String() != "path,category,size1,size2,lastMod1,lastMod2,hash1,hash2,from\n" {
+		t.Error("CSV output should only have a header", buffer.String())
+	}
+}
+
+func TestUpdateFormatNoAutosave(t *testing.T) {
+	setupUpdate(t)
+
+	format = "csv"
+
+	if err := runUpdate(nil, args); err == nil {
+		t.Error("should error on csv format without autosave")
+	}
+}
+
+func TestUpdateBadFormat(t *testing.T) {
+	setupUpdate(t)
+
+	format = "xml"
+	autosave = true
+
+	if err := runUpdate(nil, args); err == nil {
+		t.Error("should error on unknown format")
+	}
+}
+
 func TestUpdateNoInput(t *testing.T) {
 	setupUpdate(t)
 
@@ -211,6 +592,70 @@ func TestUpdateBadIndex(t *testing.T) {
 	}
 }
 
+// ageVerified rewrites the current index so every Entry was verified long ago.
+func ageVerified(t *testing.T) {
+	in, err := file.GetFs().Open(idx.GetFile(ext))
+
+	if err != nil {
+		t.Fatal("cannot open current index", err)
+	}
+
+	gz, err := gzip.NewReader(in)
+
+	if err != nil {
+		t.Fatal("cannot read current index", err)
+	}
+
+	data, err := io.ReadAll(gz)
+	in.Close()
+
+	if err != nil {
+		t.Fatal("cannot read current index", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	verifiedColumn := -1
+
+	for i, line := range lines {
+		fields := strings.Split(line, ",")
+
+		if fields[0] == "fields" {
+			verifiedColumn = slices.Index(fields, "verified") - 1
+		} else if (verifiedColumn >= 0) && (len(fields) > verifiedColumn) {
+			fields[verifiedColumn] = strconv.Itoa(1000 + i)
+			lines[i] = strings.Join(fields, ",")
+		}
+	}
+
+	var buffer bytes.Buffer
+	gzOut := gzip.NewWriter(&buffer)
+	gzOut.Write([]byte(strings.Join(lines, "\n")))
+	gzOut.Close()
+
+	if err := afero.WriteFile(file.GetFs(), idx.GetFile(ext), buffer.Bytes(), 0644); err != nil {
+		t.Fatal("cannot write current index", err)
+	}
+}
+
+// recentlyVerified returns the paths in the current index verified after ageVerified was called.
+func recentlyVerified(t *testing.T) []string {
+	updated, err := index.Load(&cfg, ext)
+
+	if err != nil {
+		t.Fatal("should be able to load updated index", err)
+	}
+
+	paths := []string{}
+
+	updated.ForEach(func(e index.Entry) {
+		if e.Verified().Unix() > 1000000 {
+			paths = append(paths, e.Path())
+		}
+	})
+
+	return paths
+}
+
 func runAndValidate(t *testing.T) {
 	if err := runUpdate(nil, args); err != nil {
 		t.Fatal("should not error on update", err)
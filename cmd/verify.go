@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	log "github.com/spf13/jwalterweatherman"
+
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/util"
+)
+
+func init() {
+	verifyCmd.Flags().IntVarP(&workers, "workers", "w", 0, "number of files to hash in parallel; overrides the config's workers value")
+	verifyCmd.Flags().StringVar(&maxReadRate, "max-read-rate", "", "maximum rate to read files, e.g. 50MB/s; overrides the config's maxReadRate value")
+	verifyCmd.Flags().IntVar(&maxFileRate, "max-file-rate", 0, "maximum number of files to hash per second; overrides the config's maxFileRate value")
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <config_file>",
+	Short: "Rehash the filesystem and check it against an existing index without saving a new index",
+	Args:  cobra.ExactArgs(1), // config file
+	RunE:  runVerify,
+}
+
+func runVerify(_ *cobra.Command, args []string) error {
+	config, err := loadConfig(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if err = setScanFlags(&config); err != nil {
+		return err
+	}
+
+	idx, err := index.Load(&config, ext)
+
+	if err != nil {
+		return err
+	}
+
+	log.INFO.Println()
+
+	result, err := util.Verify(idx)
+
+	if err != nil {
+		return err
+	}
+
+	log.INFO.Println()
+	result.Log()
+
+	// only corruption and read errors are errors; other changes are expected on a live file system
+	if (result.Counts[util.Corrupted] + result.Counts[util.Unreadable]) > 0 {
+		// empty error message => no error logged in main()
+		// but _will_ trigger an exit code of 1
+		return errors.New("")
+	}
+
+	return nil
+}
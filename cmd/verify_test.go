@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestVerify(t *testing.T) {
+	setup(t)
+
+	// new files are not errors
+	test.MakeFile(t, cfg.Root()+"/another", "another", 0644)
+
+	workers = 2
+
+	if err := runVerify(nil, args); err != nil {
+		t.Error("should not error on verify", err)
+	}
+}
+
+func TestVerifyCorrupted(t *testing.T) {
+	setup(t)
+
+	// same size & time but different contents
+	path := "test2/sub1/test2_sub1_2"
+	entry, _ := idx.Get(path)
+	test.MakeFile(t, cfg.Root()+"/"+path, "data2_1_x", 0644)
+	file.GetFs().Chtimes(cfg.Root()+"/"+path, entry.LastMod(), entry.LastMod())
+
+	err := runVerify(nil, args)
+
+	if err == nil {
+		t.Fatal("should error on verify with corrupted files")
+	}
+	if err.Error() != "" {
+		t.Error("should error with empty Error when corrupted")
+	}
+}
+
+func TestVerifyUnreadable(t *testing.T) {
+	setup(t)
+
+	test.SetupErrorFs(t, map[string]error{cfg.Root() + "/test1/test1_1": fs.ErrPermission})
+
+	err := runVerify(nil, args)
+
+	if err == nil {
+		t.Fatal("should error on verify with unreadable files")
+	}
+	if err.Error() != "" {
+		t.Error("should error with empty Error when unreadable")
+	}
+}
+
+func TestVerifyUnstable(t *testing.T) {
+	setup(t)
+
+	test.SetupErrorFs(t, map[string]error{cfg.Root() + "/test1/test1_1": index.ErrUnstable})
+
+	// files that are still being written are reported, but are not errors
+	if err := runVerify(nil, args); err != nil {
+		t.Error("should not error on verify with unstable files", err)
+	}
+}
+
+func TestVerifyInvalidWorkers(t *testing.T) {
+	setup(t)
+
+	workers = -1
+
+	if err := runVerify(nil, args); err == nil {
+		t.Error("should error with negative workers")
+	}
+}
+
+func TestVerifyBadConfig(t *testing.T) {
+	setup(t)
+
+	if err := runVerify(nil, []string{"invalid"}); err == nil {
+		t.Error("should error on invalid config")
+	}
+}
+
+func TestVerifyBadIndex(t *testing.T) {
+	setup(t)
+
+	if err := file.GetFs().Remove(idx.GetFile(ext)); err != nil {
+		t.Fatalf("cannot remove index from file system")
+	}
+
+	if err := runVerify(nil, args); err == nil {
+		t.Error("should error on missing index")
+	}
+}
+
+func TestVerifyMissingRoot(t *testing.T) {
+	setup(t)
+
+	test.RemoveDir(t, cfg.Root())
+
+	if err := runVerify(nil, args); err == nil {
+		t.Error("should error on missing root")
+	}
+}
@@ -4,21 +4,58 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	log "github.com/spf13/jwalterweatherman"
 	"golang.org/x/text/unicode/norm"
 )
 
 // Config represents the information need to load and store an Index on the filesystem.
 type Config struct {
-	root        string           // base path for the files that are indexed
-	savePath    string           // base path of the Index when saved to a file system
-	baseName    string           // default name of Index file, without extensions
-	ignoredDirs []*regexp.Regexp // list of directories to ignore when building the Index, relative to root
+	root              string        // base path for the files that are indexed
+	savePath          string        // base path of the Index when saved to a file system
+	baseName          string        // default name of Index file, without extensions
+	ignoredDirs       []pattern     // list of directories to ignore when building the Index
+	ignoredFiles      []pattern     // list of files to ignore when building the Index
+	includeOnly       []pattern     // if not empty, only files matching one of these are indexed
+	metadataFiles     []string      // file name patterns for file manager metadata, which is never indexed
+	workers           int           // number of files to hash in parallel; 0 => number of CPUs
+	hash              string        // name of the hash algorithm; empty => default
+	maxReadRate       int64         // maximum bytes read per second when hashing; 0 => unlimited
+	maxFileRate       int           // maximum files hashed per second; 0 => unlimited
+	minSize           int64         // smallest file to index; 0 => no minimum
+	maxSize           int64         // largest file to index; 0 => no maximum
+	olderThan         time.Duration // only index files last modified at least this long ago; 0 => any time
+	newerThan         time.Duration // only index files last modified within this long; 0 => any time
+	recordEmpty       bool          // add zero byte files and directories to the Index
+	symlinks          string        // how to handle symbolic links; one of the Symlinks constants
+	recordPermissions bool          // add file mode and ownership to Index Entries; Unix only
+	xattrs            bool          // add a digest of extended attributes to Index Entries
+	xattrNames        bool          // also add the names of the extended attributes
+	hashRetries       int           // times to rehash files that change while being hashed
+	checkCtime        bool          // rehash files with a changed status change time or inode, not just mtime & size
 }
 
+// Ways to handle symbolic links when building an Index.
+const (
+	SymlinksSkip   = "skip"   // do not index links
+	SymlinksRecord = "record" // index the link's target path without reading the target
+	SymlinksFollow = "follow" // index the target file or directory as if it were at the link's path
+)
+
+// DefaultMetadataFiles are the file name patterns for file manager metadata that is not indexed unless the Config
+// overrides them. These files change frequently and are not worth tracking.
+var DefaultMetadataFiles = []string{"desktop.ini", ".DS_Store"}
+
+// DefaultHashRetries is the number of times a file that changes while being hashed is rehashed before it is recorded
+// as unstable.
+const DefaultHashRetries = 2
+
 // Root returns the root directory to be used by the Index.
 func (c Config) Root() string {
 	return c.root
@@ -34,13 +71,299 @@ func (c Config) BaseName() string {
 	return c.baseName
 }
 
-// IgnoreDir returns true if the given directory matches any of the ignored directory regular expressions.
+// Workers returns the number of files that will be hashed in parallel when building the Index.
+// Defaults to the number of CPUs if not set.
+func (c Config) Workers() int {
+	if c.workers <= 0 {
+		return runtime.NumCPU()
+	}
+
+	return c.workers
+}
+
+// SetWorkers overrides the number of files hashed in parallel; 0 uses the default.
+func (c *Config) SetWorkers(workers int) error {
+	if workers < 0 {
+		return fmt.Errorf("'workers' cannot be negative: %d", workers)
+	}
+
+	c.workers = workers
+
+	return nil
+}
+
+// HashRetries returns the number of times to rehash a file whose size or modification time changed while it was being
+// hashed. 0 records the file as unstable after the first change.
+func (c Config) HashRetries() int {
+	return c.hashRetries
+}
+
+// SetHashRetries overrides the number of times to rehash files that change while being hashed.
+func (c *Config) SetHashRetries(hashRetries int) error {
+	if hashRetries < 0 {
+		return fmt.Errorf("'hashRetries' cannot be negative: %d", hashRetries)
+	}
+
+	c.hashRetries = hashRetries
+
+	return nil
+}
+
+// CheckCtime returns true if existing Entries are only reused when the file's status change time and inode are also
+// unchanged. Only supported on Unix.
+func (c Config) CheckCtime() bool {
+	return c.checkCtime
+}
+
+// SetCheckCtime overrides whether status change times and inodes are checked before reusing existing Entries.
+func (c *Config) SetCheckCtime(checkCtime bool) {
+	c.checkCtime = checkCtime
+}
+
+// Hash returns the name of the algorithm used to hash files. An empty string means the default algorithm.
+// Note that the name is not validated until an Index is created.
+func (c Config) Hash() string {
+	return c.hash
+}
+
+// SetHash overrides the name of the hash algorithm.
+func (c *Config) SetHash(hash string) {
+	c.hash = strings.ToLower(strings.TrimSpace(hash))
+}
+
+// MaxReadRate returns the maximum number of bytes per second to read when hashing files. 0 means unlimited.
+func (c Config) MaxReadRate() int64 {
+	return c.maxReadRate
+}
+
+// SetMaxReadRate overrides the maximum read rate. The rate is a number of bytes per second with an optional unit and
+// '/s' suffix, e.g. '50MB/s' or '1GiB'. Empty or 0 means unlimited.
+func (c *Config) SetMaxReadRate(rate string) error {
+	rate = strings.TrimSpace(rate)
+	rate = strings.TrimSuffix(strings.TrimSuffix(rate, "/sec"), "/s")
+
+	if rate == "" {
+		c.maxReadRate = 0
+		return nil
+	}
+
+	bytes, err := humanize.ParseBytes(rate)
+
+	if err != nil {
+		return fmt.Errorf("invalid 'maxReadRate': %v", err)
+	}
+
+	c.maxReadRate = int64(bytes)
+
+	return nil
+}
+
+// MaxFileRate returns the maximum number of files to hash per second. 0 means unlimited.
+func (c Config) MaxFileRate() int {
+	return c.maxFileRate
+}
+
+// SetMaxFileRate overrides the maximum number of files hashed per second; 0 means unlimited.
+func (c *Config) SetMaxFileRate(rate int) error {
+	if rate < 0 {
+		return fmt.Errorf("'maxFileRate' cannot be negative: %d", rate)
+	}
+
+	c.maxFileRate = rate
+
+	return nil
+}
+
+// RecordEmpty returns true if zero byte files and directories are added to the Index.
+func (c Config) RecordEmpty() bool {
+	return c.recordEmpty
+}
+
+// SetRecordEmpty overrides whether zero byte files and directories are added to the Index.
+func (c *Config) SetRecordEmpty(recordEmpty bool) {
+	c.recordEmpty = recordEmpty
+}
+
+// RecordPermissions returns true if file mode and ownership are added to the Index. Only supported on Unix.
+func (c Config) RecordPermissions() bool {
+	return c.recordPermissions
+}
+
+// SetRecordPermissions overrides whether file mode and ownership are added to the Index.
+func (c *Config) SetRecordPermissions(recordPermissions bool) {
+	c.recordPermissions = recordPermissions
+}
+
+// Xattrs returns true if a digest of each file's extended attributes is added to the Index. Only supported on Linux,
+// macOS and the BSDs.
+func (c Config) Xattrs() bool {
+	return c.xattrs
+}
+
+// SetXattrs overrides whether extended attributes are added to the Index.
+func (c *Config) SetXattrs(xattrs bool) {
+	c.xattrs = xattrs
+}
+
+// XattrNames returns true if the names of each file's extended attributes are added to the Index along with the
+// digest. Always false unless Xattrs() is true.
+func (c Config) XattrNames() bool {
+	return c.xattrs && c.xattrNames
+}
+
+// SetXattrNames overrides whether the names of extended attributes are added to the Index.
+func (c *Config) SetXattrNames(xattrNames bool) {
+	c.xattrNames = xattrNames
+}
+
+// Symlinks returns how symbolic links are handled when building the Index; one of the Symlinks constants.
+func (c Config) Symlinks() string {
+	if c.symlinks == "" {
+		return SymlinksSkip
+	}
+
+	return c.symlinks
+}
+
+// SetSymlinks overrides how symbolic links are handled. Empty uses the default, SymlinksSkip.
+func (c *Config) SetSymlinks(symlinks string) error {
+	symlinks = strings.ToLower(strings.TrimSpace(symlinks))
+
+	switch symlinks {
+	case "", SymlinksSkip, SymlinksRecord, SymlinksFollow:
+		c.symlinks = symlinks
+		return nil
+	default:
+		return fmt.Errorf("'symlinks' must be one of %s, %s or %s, not '%s'", SymlinksSkip, SymlinksRecord, SymlinksFollow, symlinks)
+	}
+}
+
+// IgnoreDir returns true if the given directory matches any of the ignored directory patterns.
 func (c Config) IgnoreDir(dir string) bool {
-	dir = norm.NFC.String(dir) // normalize to match compiled regexes
+	if p, matched := c.match(c.ignoredDirs, dir); matched {
+		log.TRACE.Println(dir, "matches", p)
+		return true
+	}
 
-	for _, re := range c.ignoredDirs {
-		if re.MatchString(dir) {
-			log.TRACE.Println(dir, "matches", re)
+	return false
+}
+
+// SetIgnoredFiles overrides the patterns for files to ignore.
+func (c *Config) SetIgnoredFiles(possiblePatterns []string) error {
+	ignoredFiles, err := compilePatterns("ignoredFiles", possiblePatterns)
+
+	if err != nil {
+		return err
+	}
+
+	c.ignoredFiles = ignoredFiles
+
+	return nil
+}
+
+// SetIncludeOnly overrides the patterns for files to index. If empty, all files are indexed.
+func (c *Config) SetIncludeOnly(possiblePatterns []string) error {
+	includeOnly, err := compilePatterns("includeOnly", possiblePatterns)
+
+	if err != nil {
+		return err
+	}
+
+	c.includeOnly = includeOnly
+
+	return nil
+}
+
+// IgnoreFile returns true if the given file matches any of the ignored file patterns or if it does not match any of
+// the include only patterns.
+func (c Config) IgnoreFile(file string) bool {
+	if p, matched := c.match(c.ignoredFiles, file); matched {
+		log.TRACE.Println(file, "matches", p)
+		return true
+	}
+
+	if len(c.includeOnly) == 0 {
+		return false
+	}
+
+	if _, matched := c.match(c.includeOnly, file); matched {
+		return false
+	}
+
+	log.TRACE.Println(file, "does not match includeOnly")
+	return true
+}
+
+// match returns the first pattern that matches the given path. Regular expressions match the full path; globs match
+// the path relative to the root.
+func (c Config) match(patterns []pattern, fullPath string) (pattern, bool) {
+	if len(patterns) == 0 {
+		return pattern{}, false
+	}
+
+	fullPath = norm.NFC.String(fullPath) // normalize to match compiled patterns
+
+	// globs never match the root itself
+	relative := ""
+	slashed := strings.Replace(fullPath, "\\", "/", -1)
+
+	if slashed != c.root {
+		relative = strings.TrimPrefix(slashed, strings.TrimSuffix(c.root, "/")+"/")
+	}
+
+	for _, p := range patterns {
+		if p.isGlob {
+			if (relative != "") && p.re.MatchString(relative) {
+				return p, true
+			}
+		} else if p.re.MatchString(fullPath) {
+			return p, true
+		}
+	}
+
+	return pattern{}, false
+}
+
+// MetadataFiles returns the file name patterns for file manager metadata.
+func (c Config) MetadataFiles() []string {
+	return c.metadataFiles
+}
+
+// SetMetadataFiles overrides the file name patterns for file manager metadata. Patterns use shell syntax, e.g. '._*'.
+// An empty list indexes all metadata files.
+func (c *Config) SetMetadataFiles(patterns []string) error {
+	c.metadataFiles = nil
+
+	return c.AddMetadataFiles(patterns)
+}
+
+// AddMetadataFiles extends the file name patterns for file manager metadata.
+func (c *Config) AddMetadataFiles(patterns []string) error {
+	for _, pattern := range patterns {
+		pattern = norm.NFC.String(strings.TrimSpace(pattern))
+
+		if pattern == "" {
+			continue
+		}
+
+		// Match checks the entire pattern for errors, even if the name does not match
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid 'metadataFiles' pattern '%s': %v", pattern, err)
+		}
+
+		c.metadataFiles = append(c.metadataFiles, pattern)
+	}
+
+	return nil
+}
+
+// IsMetadata returns true if the name of the given file matches any of the metadata file patterns.
+func (c Config) IsMetadata(file string) bool {
+	name := norm.NFC.String(filepath.Base(file))
+
+	for _, pattern := range c.metadataFiles {
+		// patterns are validated when set
+		if matched, _ := path.Match(pattern, name); matched {
 			return true
 		}
 	}
@@ -50,16 +373,22 @@ func (c Config) IgnoreDir(dir string) bool {
 
 // Formats the config as a String.
 func (c Config) String() string {
-	ignoredStrings := make([]string, len(c.ignoredDirs))
+	return fmt.Sprintf("{root: '%s', baseName: '%s', savePath: '%s', ignoredDirs: [ %s ], ignoredFiles: [ %s ], includeOnly: [ %s ], metadataFiles: [ %s ], workers: %d, hash: '%s', maxReadRate: %d, maxFileRate: %d, minSize: %d, maxSize: %d, olderThan: %v, newerThan: %v, recordEmpty: %v, symlinks: %s, recordPermissions: %v, xattrs: %v, xattrNames: %v, hashRetries: %d, checkCtime: %v}",
+		c.root, c.baseName, c.savePath, joinPatterns(c.ignoredDirs), joinPatterns(c.ignoredFiles), joinPatterns(c.includeOnly), strings.Join(c.metadataFiles, ", "),
+		c.Workers(), c.hash, c.maxReadRate, c.maxFileRate, c.minSize, c.maxSize, c.olderThan, c.newerThan, c.recordEmpty, c.Symlinks(), c.recordPermissions, c.xattrs, c.xattrNames, c.hashRetries, c.checkCtime)
+}
+
+func joinPatterns(patterns []pattern) string {
+	strs := make([]string, len(patterns))
 
-	for i, re := range c.ignoredDirs {
-		ignoredStrings[i] = re.String()
+	for i, p := range patterns {
+		strs[i] = p.String()
 	}
 
-	return fmt.Sprintf("{root: '%s', baseName: '%s', savePath: '%s', ignoredDirs: [ %s ]}", c.root, c.baseName, c.savePath, strings.Join(ignoredStrings, ", "))
+	return strings.Join(strs, ", ")
 }
 
-func new(root string, savePath string, baseName string, possibleRegexes []string) (Config, error) {
+func new(root string, savePath string, baseName string, possiblePatterns []string) (Config, error) {
 	var c Config
 
 	if root == "" {
@@ -84,28 +413,51 @@ func new(root string, savePath string, baseName string, possibleRegexes []string
 	// change Windows \ to /
 	savePath = strings.Replace(savePath, "\\", "/", -1)
 
-	var ignoredDirs []*regexp.Regexp
+	ignoredDirs, err := compilePatterns("ignoredDirs", possiblePatterns)
 
-	for _, possibleRegex := range possibleRegexes {
-		possibleRegex = strings.TrimSpace(possibleRegex)
+	if err != nil {
+		return c, err
+	}
 
-		if possibleRegex == "" {
+	c.root = root
+	c.savePath = savePath
+	c.baseName = baseName
+	c.ignoredDirs = ignoredDirs
+	c.metadataFiles = append([]string(nil), DefaultMetadataFiles...)
+	c.hashRetries = DefaultHashRetries
+	c.checkCtime = true
+
+	return c, nil
+}
+
+// compilePatterns trims and compiles the given patterns, skipping empty strings. Patterns starting with 'glob:' are
+// globs; all others are regular expressions. Returns nil if there are no patterns.
+func compilePatterns(name string, possiblePatterns []string) ([]pattern, error) {
+	var patterns []pattern
+
+	for _, possiblePattern := range possiblePatterns {
+		possiblePattern = norm.NFC.String(strings.TrimSpace(possiblePattern))
+
+		if possiblePattern == "" {
 			continue
 		}
 
-		re, err := regexp.Compile(norm.NFC.String(possibleRegex))
+		p := pattern{source: possiblePattern}
+		var err error
+
+		if glob, isGlob := strings.CutPrefix(possiblePattern, globPrefix); isGlob {
+			p.isGlob = true
+			p.re, err = GlobToRegexp(strings.TrimSpace(glob))
+		} else {
+			p.re, err = regexp.Compile(possiblePattern)
+		}
 
 		if err != nil {
-			return c, err
+			return nil, fmt.Errorf("invalid '%s': %v", name, err)
 		}
 
-		ignoredDirs = append(ignoredDirs, re)
+		patterns = append(patterns, p)
 	}
 
-	c.root = root
-	c.savePath = savePath
-	c.baseName = baseName
-	c.ignoredDirs = ignoredDirs
-
-	return c, nil
+	return patterns, nil
 }
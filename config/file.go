@@ -39,6 +39,78 @@ func Load(configFile string) (Config, error) {
 		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
 	}
 
+	if err = config.SetWorkers(v.GetInt("workers")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	config.SetHash(v.GetString("hash"))
+
+	if v.IsSet("checkCtime") {
+		config.SetCheckCtime(v.GetBool("checkCtime"))
+	}
+
+	if v.IsSet("hashRetries") {
+		if err = config.SetHashRetries(v.GetInt("hashRetries")); err != nil {
+			return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+		}
+	}
+
+	if err = config.SetMaxReadRate(v.GetString("maxReadRate")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetMaxFileRate(v.GetInt("maxFileRate")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetIgnoredFiles(v.GetStringSlice("ignoredFiles")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetIncludeOnly(v.GetStringSlice("includeOnly")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	// an explicit list replaces the defaults, even if empty
+	if v.IsSet("metadataFiles") {
+		if err = config.SetMetadataFiles(v.GetStringSlice("metadataFiles")); err != nil {
+			return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+		}
+	}
+
+	if err = config.AddMetadataFiles(v.GetStringSlice("extraMetadataFiles")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	config.SetRecordEmpty(v.GetBool("recordEmpty"))
+	config.SetRecordPermissions(v.GetBool("recordPermissions"))
+	config.SetXattrs(v.GetBool("xattrs"))
+	config.SetXattrNames(v.GetBool("xattrNames"))
+
+	if err = config.SetSymlinks(v.GetString("symlinks")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetMinSize(v.GetString("minSize")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetMaxSize(v.GetString("maxSize")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetOlderThan(v.GetString("olderThan")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.SetNewerThan(v.GetString("newerThan")); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
+	if err = config.checkFilters(); err != nil {
+		return config, fmt.Errorf("cannot read config file '%s': %v", configFile, err)
+	}
+
 	log.INFO.Printf("'%s'=%s\n", configFile, config)
 
 	return config, nil
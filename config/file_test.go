@@ -1,6 +1,8 @@
 package config
 
 import (
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -169,6 +171,368 @@ ignoredDirs: ' [] '
 	}
 }
 
+func TestConfigWithWorkers(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+workers: 3
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.Workers() != 3 {
+		t.Error("workers should be 3, not", c.Workers())
+	}
+
+	if err = c.SetWorkers(0); err != nil {
+		t.Fatal("should be able to reset workers", err)
+	}
+
+	if c.Workers() != runtime.NumCPU() {
+		t.Error("workers should default to the number of CPUs, not", c.Workers())
+	}
+}
+
+func TestConfigWithHash(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+hash: ' SHA512 '
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.Hash() != "sha512" {
+		t.Error("hash should be 'sha512', not", c.Hash())
+	}
+}
+
+func TestConfigWithInvalidWorkers(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+workers: -1
+`
+	_, err := FromString(t, config)
+
+	if err == nil {
+		t.Error("should not be able to load config with negative workers", err)
+	}
+}
+
+func TestConfigWithRates(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+maxReadRate: 50MB/s
+maxFileRate: 10
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.MaxReadRate() != 50000000 {
+		t.Error("maxReadRate should be 50MB, not", c.MaxReadRate())
+	}
+
+	if c.MaxFileRate() != 10 {
+		t.Error("maxFileRate should be 10, not", c.MaxFileRate())
+	}
+
+	for rate, expected := range map[string]int64{"": 0, "0": 0, "1024": 1024, "1KiB/s": 1024, " 2 kb/sec ": 2000} {
+		if err = c.SetMaxReadRate(rate); err != nil {
+			t.Errorf("should be able to set maxReadRate to '%s': %v", rate, err)
+		}
+
+		if c.MaxReadRate() != expected {
+			t.Errorf("maxReadRate '%s' should be %d, not %d", rate, expected, c.MaxReadRate())
+		}
+	}
+}
+
+func TestConfigWithInvalidRates(t *testing.T) {
+	for _, rates := range []string{"maxReadRate: fast", "maxReadRate: -1", "maxFileRate: -1"} {
+		_, err := FromString(t, "root: testRoot\nbaseName: testBaseName\n"+rates)
+
+		if err == nil {
+			t.Errorf("should not be able to load config with '%s'", rates)
+		}
+	}
+}
+
+func TestConfigWithFileFilters(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+ignoredFiles: [' \.tmp$ ', '']
+includeOnly: '\.(jpg|png)$'
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if len(c.ignoredFiles) != 1 {
+		t.Fatal("should have 1 regexp in ignoredFiles")
+	}
+
+	if len(c.includeOnly) != 1 {
+		t.Fatal("should have 1 regexp in includeOnly")
+	}
+
+	for file, ignored := range map[string]bool{"a/b.jpg": false, "a/b.png": false, "a/b.jpg.tmp": true, "a/b.txt": true} {
+		if c.IgnoreFile(file) != ignored {
+			t.Errorf("IgnoreFile('%s') should be %v", file, ignored)
+		}
+	}
+
+	if err = c.SetIncludeOnly(nil); err != nil {
+		t.Fatal("should be able to clear includeOnly", err)
+	}
+
+	if c.IgnoreFile("a/b.txt") {
+		t.Error("should not ignore files without includeOnly")
+	}
+}
+
+func TestConfigWithGlobs(t *testing.T) {
+	config := `root: /testRoot
+baseName: testBaseName
+ignoredDirs: ['glob: **/build', 'glob:/tmp', '.*/regex$']
+ignoredFiles: 'glob:*.tmp'
+includeOnly: ['glob:photos/**', 'glob:*.jpg']
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	for dir, ignored := range map[string]bool{"/testRoot/build": true, "/testRoot/a/b/build": true, "/testRoot/tmp": true, "/testRoot/a/tmp": false,
+		"/testRoot/a/regex": true, "/testRoot": false} {
+		if c.IgnoreDir(dir) != ignored {
+			t.Errorf("IgnoreDir('%s') should be %v", dir, ignored)
+		}
+	}
+
+	for file, ignored := range map[string]bool{"/testRoot/photos/a/b.png": false, "/testRoot/a/b.jpg": false, "/testRoot/photos/b.tmp": true, "/testRoot/a/b.png": true} {
+		if c.IgnoreFile(file) != ignored {
+			t.Errorf("IgnoreFile('%s') should be %v", file, ignored)
+		}
+	}
+
+	if !strings.Contains(c.String(), "glob: **/build") {
+		t.Error("String() should contain the original glob", c.String())
+	}
+
+	_, err = FromString(t, "root: testRoot\nbaseName: testBaseName\nignoredDirs: 'glob:a[b'")
+
+	if err == nil {
+		t.Error("should not be able to load config with an invalid glob")
+	}
+}
+
+func TestConfigWithDefaultMetadataFiles(t *testing.T) {
+	c, err := FromString(t, "root: testRoot\nbaseName: testBaseName")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	for _, file := range []string{"a/desktop.ini", ".DS_Store"} {
+		if !c.IsMetadata(file) {
+			t.Errorf("'%s' should be metadata", file)
+		}
+	}
+
+	// other file managers' metadata must be added explicitly
+	for _, file := range []string{"a/photo.jpg", "a/desktop.ini/photo.jpg", "a/my.DS_Store", "a/Thumbs.db", "a/._photo.jpg", "a/.~lock.doc#"} {
+		if c.IsMetadata(file) {
+			t.Errorf("'%s' should not be metadata", file)
+		}
+	}
+}
+
+func TestConfigWithMetadataFiles(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+metadataFiles: ['*.bak']
+extraMetadataFiles: [' .directory ']
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if len(c.MetadataFiles()) != 2 {
+		t.Fatal("should have 2 metadataFiles, not", c.MetadataFiles())
+	}
+
+	for file, metadata := range map[string]bool{"a/b.bak": true, "a/.directory": true, "a/.DS_Store": false} {
+		if c.IsMetadata(file) != metadata {
+			t.Errorf("IsMetadata('%s') should be %v", file, metadata)
+		}
+	}
+
+	// empty list indexes everything
+	c, err = FromString(t, "root: testRoot\nbaseName: testBaseName\nmetadataFiles: []")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.IsMetadata("a/.DS_Store") {
+		t.Error("should not have metadata with empty metadataFiles")
+	}
+
+	// defaults can be extended
+	c, err = FromString(t, "root: testRoot\nbaseName: testBaseName\nextraMetadataFiles: [.directory, Thumbs.db, '._*', '.~lock*']")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	for _, file := range []string{"a/.directory", "a/.DS_Store", "a/Thumbs.db", "a/._photo.jpg", "a/.~lock.doc#"} {
+		if !c.IsMetadata(file) {
+			t.Errorf("extraMetadataFiles should extend the defaults; '%s' should be metadata", file)
+		}
+	}
+}
+
+func TestConfigWithInvalidFileFilters(t *testing.T) {
+	for _, filter := range []string{"ignoredFiles: '['", "includeOnly: '('", "metadataFiles: '['", "extraMetadataFiles: 'a[b'"} {
+		_, err := FromString(t, "root: testRoot\nbaseName: testBaseName\n"+filter)
+
+		if err == nil {
+			t.Errorf("should not be able to load config with '%s'", filter)
+		}
+	}
+}
+
+func TestConfigWithSymlinks(t *testing.T) {
+	c := ForTest(t)
+
+	if c.Symlinks() != SymlinksSkip {
+		t.Error("symlinks should default to skip, not", c.Symlinks())
+	}
+
+	c, err := FromString(t, "root: testRoot\nbaseName: testBaseName\nsymlinks: ' Follow '")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.Symlinks() != SymlinksFollow {
+		t.Error("symlinks should be follow, not", c.Symlinks())
+	}
+
+	if _, err = FromString(t, "root: testRoot\nbaseName: testBaseName\nsymlinks: copy"); err == nil {
+		t.Error("should not be able to load config with invalid symlinks")
+	}
+}
+
+func TestConfigWithRecordPermissions(t *testing.T) {
+	c := ForTest(t)
+
+	if c.RecordPermissions() {
+		t.Error("permissions should not be recorded by default")
+	}
+
+	c, err := FromString(t, "root: testRoot\nbaseName: testBaseName\nrecordPermissions: true")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if !c.RecordPermissions() {
+		t.Error("permissions should be recorded")
+	}
+}
+
+func TestConfigWithXattrs(t *testing.T) {
+	c := ForTest(t)
+
+	if c.Xattrs() {
+		t.Error("extended attributes should not be recorded by default")
+	}
+
+	c, err := FromString(t, "root: testRoot\nbaseName: testBaseName\nxattrs: true")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if !c.Xattrs() {
+		t.Error("extended attributes should be recorded")
+	}
+
+	if c.XattrNames() {
+		t.Error("extended attribute names should not be recorded by default")
+	}
+
+	c.SetXattrNames(true)
+
+	if !c.XattrNames() {
+		t.Error("extended attribute names should be recorded")
+	}
+
+	// names require the digest
+	c, err = FromString(t, "root: testRoot\nbaseName: testBaseName\nxattrNames: true")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.XattrNames() {
+		t.Error("extended attribute names should not be recorded without xattrs")
+	}
+}
+
+func TestConfigWithHashRetries(t *testing.T) {
+	c := ForTest(t)
+
+	if c.HashRetries() != DefaultHashRetries {
+		t.Error("hash retries should default to", DefaultHashRetries, "not", c.HashRetries())
+	}
+
+	c, err := FromString(t, "root: testRoot\nbaseName: testBaseName\nhashRetries: 0")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.HashRetries() != 0 {
+		t.Error("hash retries should be 0, not", c.HashRetries())
+	}
+
+	if _, err = FromString(t, "root: testRoot\nbaseName: testBaseName\nhashRetries: -1"); err == nil {
+		t.Error("should not be able to load config with negative hash retries")
+	}
+}
+
+func TestConfigWithCheckCtime(t *testing.T) {
+	c := ForTest(t)
+
+	if !c.CheckCtime() {
+		t.Error("ctime should be checked by default")
+	}
+
+	c, err := FromString(t, "root: testRoot\nbaseName: testBaseName\ncheckCtime: false")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.CheckCtime() {
+		t.Error("ctime should not be checked")
+	}
+}
+
 func TestLoadMissingConfig(t *testing.T) {
 	_, err := Load("missing")
 
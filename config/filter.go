@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// MinSize returns the size of the smallest file to index. 0 means no minimum.
+func (c Config) MinSize() int64 {
+	return c.minSize
+}
+
+// SetMinSize overrides the smallest file to index. The size is a number of bytes with an optional unit, e.g. '1MB'.
+// Empty or 0 means no minimum.
+func (c *Config) SetMinSize(size string) error {
+	bytes, err := parseSize("minSize", size)
+
+	if err != nil {
+		return err
+	}
+
+	c.minSize = bytes
+
+	return nil
+}
+
+// MaxSize returns the size of the largest file to index. 0 means no maximum.
+func (c Config) MaxSize() int64 {
+	return c.maxSize
+}
+
+// SetMaxSize overrides the largest file to index. The size is a number of bytes with an optional unit, e.g. '10GiB'.
+// Empty or 0 means no maximum.
+func (c *Config) SetMaxSize(size string) error {
+	bytes, err := parseSize("maxSize", size)
+
+	if err != nil {
+		return err
+	}
+
+	c.maxSize = bytes
+
+	return nil
+}
+
+// OlderThan returns how long ago a file must have been modified to be indexed. 0 means any time.
+func (c Config) OlderThan() time.Duration {
+	return c.olderThan
+}
+
+// SetOlderThan overrides how long ago a file must have been modified to be indexed. The age is a Go duration, e.g.
+// '12h', or a number of days or weeks, e.g. '7d' or '2w'. Empty or 0 means any time.
+func (c *Config) SetOlderThan(age string) error {
+	d, err := parseAge("olderThan", age)
+
+	if err != nil {
+		return err
+	}
+
+	c.olderThan = d
+
+	return nil
+}
+
+// NewerThan returns how recently a file must have been modified to be indexed. 0 means any time.
+func (c Config) NewerThan() time.Duration {
+	return c.newerThan
+}
+
+// SetNewerThan overrides how recently a file must have been modified to be indexed. The age uses the same format as
+// SetOlderThan. Empty or 0 means any time.
+func (c *Config) SetNewerThan(age string) error {
+	d, err := parseAge("newerThan", age)
+
+	if err != nil {
+		return err
+	}
+
+	c.newerThan = d
+
+	return nil
+}
+
+// FilterFile returns true if a file with the given size and modification time should not be indexed because it is
+// outside the Config's size or age limits. Ages are relative to now.
+func (c Config) FilterFile(size int64, lastMod time.Time, now time.Time) bool {
+	if size < c.minSize {
+		return true
+	}
+
+	if (c.maxSize > 0) && (size > c.maxSize) {
+		return true
+	}
+
+	age := now.Sub(lastMod)
+
+	if (c.olderThan > 0) && (age < c.olderThan) {
+		return true
+	}
+
+	if (c.newerThan > 0) && (age > c.newerThan) {
+		return true
+	}
+
+	return false
+}
+
+// checkFilters returns an error if the limits would filter every file.
+func (c Config) checkFilters() error {
+	if (c.maxSize > 0) && (c.minSize > c.maxSize) {
+		return fmt.Errorf("'minSize' %d cannot be larger than 'maxSize' %d", c.minSize, c.maxSize)
+	}
+
+	if (c.newerThan > 0) && (c.olderThan >= c.newerThan) {
+		return fmt.Errorf("'olderThan' %v must be less than 'newerThan' %v", c.olderThan, c.newerThan)
+	}
+
+	return nil
+}
+
+func parseSize(name string, size string) (int64, error) {
+	size = strings.TrimSpace(size)
+
+	if size == "" {
+		return 0, nil
+	}
+
+	bytes, err := humanize.ParseBytes(size)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s': %v", name, err)
+	}
+
+	return int64(bytes), nil
+}
+
+// parseAge parses a Go duration or a number of days or weeks.
+func parseAge(name string, age string) (time.Duration, error) {
+	age = strings.TrimSpace(age)
+
+	if age == "" {
+		return 0, nil
+	}
+
+	var d time.Duration
+	var err error
+
+	if unit := age[len(age)-1]; (unit == 'd') || (unit == 'w') {
+		var n float64
+		n, err = strconv.ParseFloat(age[:len(age)-1], 64)
+
+		d = time.Duration(n * float64(24*time.Hour))
+
+		if unit == 'w' {
+			d *= 7
+		}
+	} else {
+		d, err = time.ParseDuration(age)
+	}
+
+	if (err == nil) && (d < 0) {
+		err = errors.New("cannot be negative")
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid '%s' '%s': %v", name, age, err)
+	}
+
+	return d, nil
+}
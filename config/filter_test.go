@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigWithFilters(t *testing.T) {
+	config := `root: testRoot
+baseName: testBaseName
+minSize: 1KB
+maxSize: ' 1 GiB '
+olderThan: 1d
+newerThan: 2w
+`
+	c, err := FromString(t, config)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	if c.MinSize() != 1000 {
+		t.Error("minSize should be 1000, not", c.MinSize())
+	}
+
+	if c.MaxSize() != 1<<30 {
+		t.Error("maxSize should be 1GiB, not", c.MaxSize())
+	}
+
+	if c.OlderThan() != 24*time.Hour {
+		t.Error("olderThan should be 1 day, not", c.OlderThan())
+	}
+
+	if c.NewerThan() != 14*24*time.Hour {
+		t.Error("newerThan should be 2 weeks, not", c.NewerThan())
+	}
+
+	now := time.Now()
+	day := 24 * time.Hour
+
+	tests := []struct {
+		size     int64
+		age      time.Duration
+		filtered bool
+	}{
+		{1000, 2 * day, false},
+		{1 << 30, 13 * day, false},
+		{999, 2 * day, true},
+		{1<<30 + 1, 2 * day, true},
+		{1000, 12 * time.Hour, true},
+		{1000, 15 * day, true},
+	}
+
+	for _, test := range tests {
+		if c.FilterFile(test.size, now.Add(-test.age), now) != test.filtered {
+			t.Errorf("FilterFile(%d, %v ago) should be %v", test.size, test.age, test.filtered)
+		}
+	}
+
+	// unset limits filter nothing
+	c = ForTest(t)
+
+	if c.FilterFile(1, now.Add(time.Hour), now) || c.FilterFile(1<<40, time.Time{}, now) {
+		t.Error("should not filter without limits")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	for age, expected := range map[string]time.Duration{"": 0, "0": 0, "90m": 90 * time.Minute, "1.5d": 36 * time.Hour, "1w": 7 * 24 * time.Hour} {
+		d, err := parseAge("age", age)
+
+		if err != nil {
+			t.Errorf("cannot parse '%s': %v", age, err)
+		}
+
+		if d != expected {
+			t.Errorf("'%s' should be %v, not %v", age, expected, d)
+		}
+	}
+}
+
+func TestConfigWithInvalidFilters(t *testing.T) {
+	filters := []string{"minSize: big", "maxSize: -1", "olderThan: 1y", "newerThan: -1d", "olderThan: xd",
+		"minSize: 2KB\nmaxSize: 1KB", "olderThan: 2d\nnewerThan: 1d"}
+
+	for _, filter := range filters {
+		_, err := FromString(t, "root: testRoot\nbaseName: testBaseName\n"+filter)
+
+		if err == nil {
+			t.Errorf("should not be able to load config with '%s'", filter)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// globPrefix marks a pattern in the Config as a glob rather than a regular expression.
+const globPrefix = "glob:"
+
+// pattern matches paths with either a regular expression against the full path or a glob relative to the root.
+type pattern struct {
+	re     *regexp.Regexp
+	isGlob bool
+	source string // as written in the Config
+}
+
+func (p pattern) String() string {
+	if p.isGlob {
+		return p.source
+	}
+
+	return p.re.String()
+}
+
+// GlobToRegexp converts a gitignore style glob into an anchored regular expression that matches '/' separated paths
+// relative to the glob's base directory. '*' and '?' do not match '/', '**' matches any number of directories and
+// '\' escapes the next character. Globs without a '/' match names at any depth; a leading '/' only anchors the glob.
+// A trailing '/' is ignored.
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	glob = strings.TrimSuffix(glob, "/")
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	if glob == "" {
+		return nil, errors.New("empty glob")
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		// '**' is only special as a whole path component
+		startOfComponent := (i == 0) || (glob[i-1] == '/')
+
+		switch c := glob[i]; {
+		case startOfComponent && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case startOfComponent && (glob[i:] == "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			// allow ']' as the first character in the class
+			end := strings.IndexByte(glob[min(i+2, len(glob)):], ']')
+
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class in glob '%s'", glob)
+			}
+
+			end += min(i+2, len(glob))
+			class := glob[i+1 : end]
+
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case c == '\\':
+			if i == len(glob)-1 {
+				return nil, fmt.Errorf("trailing '\\' in glob '%s'", glob)
+			}
+
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
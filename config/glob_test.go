@@ -0,0 +1,55 @@
+package config
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob       string
+		matches    []string
+		notMatches []string
+	}{
+		{"*.log", []string{"a.log", "a/b/c.log"}, []string{"a.log/b", "alog"}},
+		{"/build", []string{"build"}, []string{"a/build", "build/a"}},
+		{"build/", []string{"build", "a/build"}, []string{"builds"}},
+		{"a/*.txt", []string{"a/b.txt"}, []string{"a/b/c.txt", "b/a/c.txt"}},
+		{"**/cache", []string{"cache", "a/cache", "a/b/cache"}, []string{"a/cache2"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"a/xb", "b"}},
+		{"a/**", []string{"a/b", "a/b/c"}, []string{"a", "b/a/c"}},
+		{"a**b", []string{"ab", "axxb"}, []string{"a/b"}},
+		{"file?.[ch]", []string{"file1.c", "x/fileA.h"}, []string{"file10.c", "file1.o"}},
+		{"[!a]*", []string{"b", "x/bc"}, []string{"a", "abc"}},
+		{"[]x]", []string{"]", "x"}, []string{"y"}},
+		{`\*.txt`, []string{"*.txt"}, []string{"a.txt"}},
+		{"a+b(1).txt", []string{"a+b(1).txt"}, []string{"aab1.txt"}},
+		{"fotos/año", []string{"fotos/año"}, []string{"fotos/ano"}},
+	}
+
+	for _, test := range tests {
+		re, err := GlobToRegexp(test.glob)
+
+		if err != nil {
+			t.Errorf("cannot convert glob '%s': %v", test.glob, err)
+			continue
+		}
+
+		for _, path := range test.matches {
+			if !re.MatchString(path) {
+				t.Errorf("glob '%s' (%v) should match '%s'", test.glob, re, path)
+			}
+		}
+
+		for _, path := range test.notMatches {
+			if re.MatchString(path) {
+				t.Errorf("glob '%s' (%v) should not match '%s'", test.glob, re, path)
+			}
+		}
+	}
+}
+
+func TestInvalidGlobToRegexp(t *testing.T) {
+	for _, glob := range []string{"", "/", "a[b", `a\`, "[]"} {
+		if _, err := GlobToRegexp(glob); err == nil {
+			t.Errorf("glob '%s' should be invalid", glob)
+		}
+	}
+}
@@ -46,6 +46,18 @@ func FromString(t *testing.T, configString string) (Config, error) {
 	return c, err
 }
 
+// FromStringKeepFs is like FromString, but keeps the current file system, e.g. the real one, after the Config is loaded.
+// Use this for tests on files that the in-memory file system cannot create, like links.
+func FromStringKeepFs(t *testing.T, configString string) (Config, error) {
+	fs := file.GetFs()
+
+	c, err := FromString(t, configString)
+
+	file.SetFs(fs)
+
+	return c, err
+}
+
 func setupViperForTest(t *testing.T) {
 	test.SetupTestFs(t)
 
@@ -1,14 +1,19 @@
 package index
 
 import (
-	"crypto/sha256"
 	"encoding/base64"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
 	gopath "path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
@@ -17,19 +22,60 @@ import (
 	"github.com/hpresnall/yabrc/file"
 )
 
-// package level hash => not safe for concurrency
-var sha256er = sha256.New()
-
 // Entry represents the data for a single file in the Index.
 type Entry struct {
-	path    string
-	lastMod time.Time // file modification time
-	size    int64     // file size in bytes
-	hash    string    // hash of file contents
+	path       string
+	lastMod    time.Time // file modification time
+	size       int64     // file size in bytes
+	hash       string    // hash of file contents; empty for error Entries
+	verified   time.Time // when the file was last hashed or, for error Entries, when reading failed; zero if unknown
+	err        string    // kind of error if the file could not be read; empty otherwise
+	fileType   string    // empty for regular files; see Type()
+	target     string    // target path of symbolic links; empty otherwise
+	perms      Permissions
+	hasPerms   bool      // false if permissions were not recorded
+	xattrs     string    // digest of all extended attributes; empty if not recorded
+	xattrNames string    // escaped, sorted names of the extended attributes, separated by '/'; empty if not recorded
+	hardLink   string    // first path, in sort order, of the files hard linked to this one; empty if not linked
+	ctime      time.Time // status change time; zero if not recorded
+	inode      uint64
+}
+
+// Types of Entries. Regular files are stored with an empty type.
+const (
+	TypeFile    = "file"
+	TypeDir     = "dir"
+	TypeSymlink = "symlink"
+)
+
+// Kinds of errors recorded for files that cannot be read.
+const (
+	ErrorPermission = "permission"
+	ErrorIO         = "io"
+	ErrorOther      = "other"
+	ErrorUnstable   = "unstable"
+)
+
+// ErrUnstable is the error for files that kept changing while being hashed.
+var ErrUnstable = errors.New("file changed while being hashed")
+
+// ErrorKind classifies the error from reading a file.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return ErrorPermission
+	case errors.Is(err, syscall.EIO):
+		return ErrorIO
+	case errors.Is(err, ErrUnstable):
+		return ErrorUnstable
+	default:
+		return ErrorOther
+	}
 }
 
 // internal use only; Entries should only be created by Index
-func buildEntry(path string, info os.FileInfo) (Entry, error) {
+// the given hash is reset before use and must not be shared across goroutines
+func buildEntry(path string, info os.FileInfo, h hash.Hash) (Entry, error) {
 	var e Entry
 
 	if path == "" {
@@ -46,7 +92,11 @@ func buildEntry(path string, info os.FileInfo) (Entry, error) {
 		return e, fmt.Errorf("path '%s' does not match FileInfo.Name() '%s'", path, info.Name())
 	}
 
-	// read all of the entryFile into sha256; use the actual entryFile name, not the normalized path
+	if h == nil {
+		return e, errors.New("hash cannot be nil")
+	}
+
+	// read all of the entryFile into the hash; use the actual entryFile name, not the normalized path
 	entryFile, err := file.GetFs().Open(gopath.Join(gopath.Dir(path), info.Name()))
 
 	if err != nil {
@@ -54,21 +104,90 @@ func buildEntry(path string, info os.FileInfo) (Entry, error) {
 	}
 
 	defer entryFile.Close()
-	sha256er.Reset()
+	h.Reset()
 
-	if _, err = io.Copy(sha256er, entryFile); err != nil {
+	if _, err = io.Copy(h, entryFile); err != nil {
 		return e, err
 	}
 
 	// use RawStdEncoding to avoid padding
-	// all sha256 hashes are the same length and all values would need padding anyway
-	sha := sha256er.Sum(nil)
-	base64 := base64.RawStdEncoding.EncodeToString(sha)
+	// all hashes from an algorithm are the same length and all values would need padding anyway
+	sum := h.Sum(nil)
+	base64 := base64.RawStdEncoding.EncodeToString(sum)
 
 	e.path = filepath.Clean(path) // note using normalized path, not info.Name()
 	e.lastMod = info.ModTime()
 	e.size = info.Size()
 	e.hash = base64
+	e.verified = time.Now().Truncate(time.Second) // stored as Unix time
+
+	return e, nil
+}
+
+// internal use only; see Index.BuildErrorEntry()
+// info may be nil if the file could not be read at all
+func buildErrorEntry(path string, info os.FileInfo, err error) (Entry, error) {
+	var e Entry
+
+	if path == "" {
+		return e, errors.New("path cannot be empty")
+	}
+
+	if err == nil {
+		return e, errors.New("err cannot be nil")
+	}
+
+	e.path = filepath.Clean(path)
+	e.err = ErrorKind(err)
+	e.verified = time.Now().Truncate(time.Second)
+
+	if info != nil {
+		e.lastMod = info.ModTime()
+		e.size = info.Size()
+	}
+
+	return e, nil
+}
+
+// internal use only; see Index.BuildDirEntry()
+func buildDirEntry(path string, info os.FileInfo) (Entry, error) {
+	var e Entry
+
+	if path == "" {
+		return e, errors.New("path cannot be empty")
+	}
+
+	if (info == nil) || !info.IsDir() {
+		return e, fmt.Errorf("'%s' is not a directory", path)
+	}
+
+	e.path = filepath.Clean(path)
+	e.lastMod = info.ModTime()
+	e.fileType = TypeDir
+
+	return e, nil
+}
+
+// internal use only; see Index.BuildSymlinkEntry()
+func buildSymlinkEntry(path string, info os.FileInfo, target string) (Entry, error) {
+	var e Entry
+
+	if path == "" {
+		return e, errors.New("path cannot be empty")
+	}
+
+	if (info == nil) || ((info.Mode() & os.ModeSymlink) == 0) {
+		return e, fmt.Errorf("'%s' is not a symbolic link", path)
+	}
+
+	if target == "" {
+		return e, errors.New("target cannot be empty")
+	}
+
+	e.path = filepath.Clean(path)
+	e.lastMod = info.ModTime()
+	e.fileType = TypeSymlink
+	e.target = norm.NFC.String(filepath.ToSlash(target)) // compare the same on all platforms
 
 	return e, nil
 }
@@ -93,17 +212,196 @@ func (e Entry) Hash() string {
 	return e.hash
 }
 
+// Verified gets the time the file's contents were last hashed. Entries reused from an existing index keep the
+// original time. Zero if unknown, i.e. for older indexes.
+func (e Entry) Verified() time.Time {
+	return e.verified
+}
+
+// Error gets the kind of error if the file could not be read. Empty if the file was read successfully.
+func (e Entry) Error() string {
+	return e.err
+}
+
+// IsError returns true if the file could not be read. Error Entries have no hash.
+func (e Entry) IsError() bool {
+	return e.err != ""
+}
+
+// Target gets the path a symbolic link points to, as stored in the link. Empty for other types.
+func (e Entry) Target() string {
+	return e.target
+}
+
+// Permissions gets the file's mode and ownership. Returns false if they were not recorded.
+func (e Entry) Permissions() (Permissions, bool) {
+	return e.perms, e.hasPerms
+}
+
+// Xattrs gets a digest of the file's extended attributes. Returns false if they were not recorded.
+func (e Entry) Xattrs() (string, bool) {
+	return e.xattrs, e.xattrs != ""
+}
+
+// XattrNames gets the sorted names of the file's extended attributes. Returns nil if the names were not recorded or
+// the file has no attributes.
+func (e Entry) XattrNames() []string {
+	// validated when the Index was loaded
+	names, _ := splitXattrNames(e.xattrNames)
+	return names
+}
+
+// HardLink gets the first path, in sort order, of the group of files hard linked to this one. Every Entry in the group,
+// including the first, has the same value. Empty if the file is not linked to any other file in the Index.
+func (e Entry) HardLink() string {
+	return e.hardLink
+}
+
+// Status gets the file's status change time and inode. Returns false if they were not recorded; they are only
+// available on Unix.
+func (e Entry) Status() (time.Time, uint64, bool) {
+	return e.ctime, e.inode, !e.ctime.IsZero()
+}
+
+// StatusChanged returns true if the given file's status change time or inode differs from the Entry's, i.e. the file
+// was replaced or its contents or metadata changed, even if the modification time did not. Times are compared in
+// seconds, as stored in the Index. Always false if either the Entry or the file has no status.
+func (e Entry) StatusChanged(info os.FileInfo) bool {
+	ctime, inode, exists := readStatus(info)
+
+	if !exists || e.ctime.IsZero() {
+		return false
+	}
+
+	return (ctime.Unix() != e.ctime.Unix()) || (inode != e.inode)
+}
+
+// Type gets the type of the Entry; one of TypeFile, TypeDir or TypeSymlink.
+func (e Entry) Type() string {
+	if e.fileType == "" {
+		return TypeFile
+	}
+
+	return e.fileType
+}
+
+// IsFile returns true if the Entry is for a regular file.
+func (e Entry) IsFile() bool {
+	return e.fileType == ""
+}
+
+// IsDir returns true if the Entry is for a directory. Directory Entries have no size or hash.
+func (e Entry) IsDir() bool {
+	return e.fileType == TypeDir
+}
+
+// IsSymlink returns true if the Entry is for a symbolic link that was recorded rather than followed.
+// Symbolic link Entries have a target instead of a size or hash.
+func (e Entry) IsSymlink() bool {
+	return e.fileType == TypeSymlink
+}
+
 // IsValid returns true if all the Entry's fields are set correctly.
 func (e Entry) IsValid() bool {
-	// 43 == size of base64 encoded sha 256 without padding
-	return (e.path != "") && !e.lastMod.IsZero() && (e.size > 0) && (e.hash != "") && (len(e.hash) == 43)
+	if e.IsError() {
+		// the file may not have been readable at all, so only the path and time are required
+		return (e.path != "") && !e.verified.IsZero() && (e.hash == "")
+	}
+
+	switch e.fileType {
+	case "":
+		// hash must be the size of a base64 encoded hash, without padding, from one of the known Hashers
+		// zero byte files are only stored if the Config records empty files, but they still have a hash
+		return (e.path != "") && !e.lastMod.IsZero() && (e.size >= 0) && (e.hash != "") && isValidHash(e.hash)
+	case TypeDir:
+		return (e.path != "") && !e.lastMod.IsZero() && (e.size == 0) && (e.hash == "") && (e.target == "")
+	case TypeSymlink:
+		return (e.path != "") && !e.lastMod.IsZero() && (e.size == 0) && (e.hash == "") && (e.target != "")
+	default:
+		return false
+	}
 }
 
-// AsCsv returns the entry as a comma separate string.
+// the Entry fields, in the order output by record()
+var entryFields = []string{"path", "lastMod", "size", "hash", "verified", "error", "type", "target", "mode", "uid", "gid", "xattrs", "hardLink", "ctime", "inode", "xattrNames"}
+
+// record returns the Entry's fields as strings, in the same order as entryFields.
+func (e Entry) record() []string {
+	verified := ""
+
+	if !e.verified.IsZero() {
+		verified = strconv.FormatInt(e.verified.Unix(), 10)
+	}
+
+	lastMod := ""
+
+	// error Entries may not have a time
+	if !e.lastMod.IsZero() {
+		lastMod = strconv.FormatInt(e.lastMod.Unix(), 10)
+	}
+
+	mode, uid, gid := "", "", ""
+
+	if e.hasPerms {
+		mode = e.perms.Octal()
+		uid = strconv.Itoa(e.perms.UID)
+		gid = strconv.Itoa(e.perms.GID)
+	}
+
+	ctime, inode := "", ""
+
+	if !e.ctime.IsZero() {
+		ctime = strconv.FormatInt(e.ctime.Unix(), 10)
+		inode = strconv.FormatUint(e.inode, 10)
+	}
+
+	return []string{e.path, lastMod, strconv.FormatInt(e.size, 10), e.hash, verified, e.err, e.fileType, e.target, mode, uid, gid, e.xattrs, e.hardLink, ctime, inode, e.xattrNames}
+}
+
+// AsCsv returns the entry as a comma separate string, quoting the path if needed.
 func (e Entry) AsCsv() string {
-	return fmt.Sprintf("%s,%d,%d,%s", e.path, e.lastMod.Unix(), e.size, e.hash)
+	var b strings.Builder
+
+	// writing to a strings.Builder cannot fail
+	w := csv.NewWriter(&b)
+	_ = w.Write(e.record())
+	w.Flush()
+
+	return strings.TrimSuffix(b.String(), "\n")
 }
 
 func (e Entry) String() string {
-	return fmt.Sprintf("{path: '%s', lastMod: %s, size: %s, hash: %s}", e.path, humanize.Time(e.lastMod), humanize.Bytes(uint64(e.size)), e.hash)
+	if e.IsError() {
+		return fmt.Sprintf("{path: '%s', error: %s, checked: %s}", e.path, e.err, humanize.Time(e.verified))
+	}
+
+	if e.IsDir() {
+		return fmt.Sprintf("{path: '%s', type: %s, lastMod: %s}", e.path, e.fileType, humanize.Time(e.lastMod))
+	}
+
+	if e.IsSymlink() {
+		return fmt.Sprintf("{path: '%s', type: %s, target: '%s', lastMod: %s}", e.path, e.fileType, e.target, humanize.Time(e.lastMod))
+	}
+
+	verified := "unknown"
+
+	if !e.verified.IsZero() {
+		verified = humanize.Time(e.verified)
+	}
+
+	perms := ""
+
+	if e.hasPerms {
+		perms = fmt.Sprintf(", permissions: %v", e.perms)
+	}
+
+	if e.xattrs != "" {
+		perms += ", xattrs: " + e.xattrs
+	}
+
+	if e.hardLink != "" {
+		perms += fmt.Sprintf(", hardLink: '%s'", e.hardLink)
+	}
+
+	return fmt.Sprintf("{path: '%s', lastMod: %s, size: %s, hash: %s, verified: %s%s}", e.path, humanize.Time(e.lastMod), humanize.Bytes(uint64(e.size)), e.hash, verified, perms)
 }
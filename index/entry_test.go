@@ -1,12 +1,20 @@
 package index
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/spf13/afero"
 
+	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
 	"github.com/hpresnall/yabrc/test"
 )
@@ -14,7 +22,7 @@ import (
 func TestEntryFromFile(t *testing.T) {
 	_, info := setupEntryFs(t)
 
-	e, err := buildEntry("./test", info)
+	e, err := buildEntry("./test", info, sha256.New())
 
 	if err != nil {
 		t.Fatal("cannot build entry", err)
@@ -36,8 +44,27 @@ func TestEntryFromFile(t *testing.T) {
 		t.Errorf("incorrect hash '%s' is not '%s'", e.Hash(), "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg")
 	}
 
-	// for coverage
-	e.AsCsv()
+	if time.Since(e.Verified()) > time.Minute {
+		t.Error("verified should be now, not", e.Verified())
+	}
+
+	if e.AsCsv() != fmt.Sprintf("test,%d,4,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,%d,,,,,,,,,,,", info.ModTime().Unix(), e.Verified().Unix()) {
+		t.Error("incorrect CSV", e.AsCsv())
+	}
+
+	// unknown verified time should be empty
+	e.verified = time.Time{}
+
+	if !strings.HasSuffix(e.AsCsv(), "Cgg,,,,,,,,,,,,") {
+		t.Error("unknown verified time should be empty in CSV", e.AsCsv())
+	}
+
+	// paths with commas should be quoted
+	e.path = "test,path"
+
+	if !strings.HasPrefix(e.AsCsv(), "\"test,path\",") {
+		t.Error("path should be quoted in CSV", e.AsCsv())
+	}
 }
 
 func TestEntryFromMissingFile(t *testing.T) {
@@ -46,7 +73,7 @@ func TestEntryFromMissingFile(t *testing.T) {
 	// missing file but valid info
 	testFs.Remove("test")
 
-	_, err := buildEntry("test", info)
+	_, err := buildEntry("test", info, sha256.New())
 
 	if err == nil {
 		t.Error("should fail to build entry from missing file", err)
@@ -57,7 +84,7 @@ func TestEntryFromEmptyPath(t *testing.T) {
 	_, info := setupEntryFs(t)
 
 	// path does not match info
-	_, err := buildEntry("", info)
+	_, err := buildEntry("", info, sha256.New())
 
 	if err == nil {
 		t.Error("should fail to build entry with empty path", err)
@@ -68,7 +95,7 @@ func TestEntryFromWrongPath(t *testing.T) {
 	_, info := setupEntryFs(t)
 
 	// path does not match info
-	_, err := buildEntry("another", info)
+	_, err := buildEntry("another", info, sha256.New())
 
 	if err == nil {
 		t.Error("should fail to build entry when path and info are not the same", err)
@@ -76,13 +103,23 @@ func TestEntryFromWrongPath(t *testing.T) {
 }
 
 func TestEntryFromNilInfo(t *testing.T) {
-	_, err := buildEntry("test", nil)
+	_, err := buildEntry("test", nil, sha256.New())
 
 	if err == nil {
 		t.Error("should fail to build entry with nil info", err)
 	}
 }
 
+func TestEntryFromNilHash(t *testing.T) {
+	_, info := setupEntryFs(t)
+
+	_, err := buildEntry("test", info, nil)
+
+	if err == nil {
+		t.Error("should fail to build entry with nil hash", err)
+	}
+}
+
 func TestValidEntry(t *testing.T) {
 	e := Entry{}
 
@@ -121,6 +158,179 @@ func TestValidEntry(t *testing.T) {
 	}
 }
 
+func TestErrorEntry(t *testing.T) {
+	_, info := setupEntryFs(t)
+
+	e, err := buildErrorEntry("./test", info, fs.ErrPermission)
+
+	if err != nil {
+		t.Fatal("cannot build error entry", err)
+	}
+
+	if !e.IsValid() || !e.IsError() {
+		t.Fatal("error entry is not valid", e)
+	}
+
+	if (e.Error() != ErrorPermission) || (e.Path() != "test") || (e.Size() != 4) || (e.Hash() != "") || e.Verified().IsZero() {
+		t.Error("incorrect error entry", e)
+	}
+
+	if !strings.Contains(e.String(), "error: permission") {
+		t.Error("String() should include the error", e)
+	}
+
+	// no FileInfo
+	e, err = buildErrorEntry("test", nil, errors.New("other"))
+
+	if err != nil {
+		t.Fatal("cannot build error entry without FileInfo", err)
+	}
+
+	if !e.IsValid() || (e.Error() != ErrorOther) || !e.LastMod().IsZero() {
+		t.Error("incorrect error entry", e)
+	}
+
+	if _, err = buildErrorEntry("", info, fs.ErrPermission); err == nil {
+		t.Error("should fail to build error entry with empty path")
+	}
+
+	if _, err = buildErrorEntry("test", info, nil); err == nil {
+		t.Error("should fail to build error entry without an error")
+	}
+
+	// error entries cannot have hashes
+	e.hash = "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"
+
+	if e.IsValid() {
+		t.Error("error entry with a hash should not be valid")
+	}
+}
+
+func TestDirEntry(t *testing.T) {
+	testFs, info := setupEntryFs(t)
+
+	if err := testFs.Mkdir("dir", 0755); err != nil {
+		t.Fatal("cannot make dir", err)
+	}
+
+	dirInfo, _ := testFs.Stat("dir")
+	e, err := buildDirEntry("./dir", dirInfo)
+
+	if err != nil {
+		t.Fatal("cannot build dir entry", err)
+	}
+
+	if !e.IsValid() || !e.IsDir() || e.IsFile() || (e.Type() != TypeDir) {
+		t.Fatal("dir entry is not valid", e)
+	}
+
+	if (e.Path() != "dir") || (e.Size() != 0) || (e.Hash() != "") || !e.LastMod().Equal(dirInfo.ModTime()) {
+		t.Error("incorrect dir entry", e)
+	}
+
+	if !strings.HasSuffix(e.AsCsv(), ",dir,,,,,,,,,") {
+		t.Error("dir type should be in CSV", e.AsCsv())
+	}
+
+	if !strings.Contains(e.String(), "type: dir") {
+		t.Error("String() should include the type", e)
+	}
+
+	// directories cannot have hashes
+	e.hash = "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"
+
+	if e.IsValid() {
+		t.Error("dir entry with a hash should not be valid")
+	}
+
+	e.hash = ""
+	e.fileType = "unknown"
+
+	if e.IsValid() {
+		t.Error("entry with an unknown type should not be valid")
+	}
+
+	if _, err = buildDirEntry("test", info); err == nil {
+		t.Error("should fail to build dir entry for a file")
+	}
+
+	if _, err = buildDirEntry("", dirInfo); err == nil {
+		t.Error("should fail to build dir entry with empty path")
+	}
+}
+
+func TestSymlinkEntry(t *testing.T) {
+	dir := test.SetupOsFs(t)
+	test.MakeFile(t, dir+"/test", "test", 0644)
+	test.MakeSymlink(t, "test", dir+"/link")
+
+	info, _ := os.Lstat(dir + "/link")
+	e, err := buildSymlinkEntry(dir+"/link", info, "test")
+
+	if err != nil {
+		t.Fatal("cannot build symlink entry", err)
+	}
+
+	if !e.IsValid() || !e.IsSymlink() || e.IsFile() || (e.Type() != TypeSymlink) || (e.Target() != "test") {
+		t.Fatal("symlink entry is not valid", e)
+	}
+
+	if !strings.HasSuffix(e.AsCsv(), ",symlink,test,,,,,,,,") || !strings.Contains(e.String(), "target: 'test'") {
+		t.Error("target should be output", e.AsCsv(), e)
+	}
+
+	e.target = ""
+
+	if e.IsValid() {
+		t.Error("symlink entry without a target should not be valid")
+	}
+
+	fileInfo, _ := os.Lstat(dir + "/test")
+
+	if _, err = buildSymlinkEntry(dir+"/test", fileInfo, "test"); err == nil {
+		t.Error("should fail to build symlink entry for a file")
+	}
+
+	if _, err = buildSymlinkEntry(dir+"/link", info, ""); err == nil {
+		t.Error("should fail to build symlink entry without a target")
+	}
+
+	if _, err = buildSymlinkEntry("", info, "test"); err == nil {
+		t.Error("should fail to build symlink entry with empty path")
+	}
+}
+
+func TestZeroByteEntry(t *testing.T) {
+	testFs, _ := setupEntryFs(t)
+
+	afero.WriteFile(testFs, "empty", []byte{}, 0644)
+	info, _ := testFs.Stat("empty")
+
+	e, err := buildEntry("empty", info, sha256.New())
+
+	if err != nil {
+		t.Fatal("cannot build entry", err)
+	}
+
+	if !e.IsValid() || !e.IsFile() || (e.Type() != TypeFile) || (e.Size() != 0) || (e.Hash() == "") {
+		t.Error("zero byte entry is not valid", e)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	kinds := map[error]string{
+		fs.ErrPermission: ErrorPermission,
+		&fs.PathError{Op: "read", Path: "test", Err: syscall.EIO}: ErrorIO,
+		fs.ErrClosed: ErrorOther,
+	}
+
+	for err, kind := range kinds {
+		if ErrorKind(err) != kind {
+			t.Errorf("'%v' should be '%s', not '%s'", err, kind, ErrorKind(err))
+		}
+	}
+}
+
 func setupEntryFs(t *testing.T) (afero.Fs, os.FileInfo) {
 	test.SetupTestFs(t)
 	testFs := file.GetFs()
@@ -139,3 +349,188 @@ func setupEntryFs(t *testing.T) (afero.Fs, os.FileInfo) {
 
 	return testFs, info
 }
+
+func TestPermissions(t *testing.T) {
+	p := Permissions{Mode: 0755 | os.ModeSetuid | os.ModeSticky, UID: 1000, GID: 100}
+
+	if p.Octal() != "5755" {
+		t.Error("incorrect octal mode", p.Octal())
+	}
+
+	p2, err := parsePermissions(p.Octal(), "1000", "100")
+
+	if err != nil {
+		t.Fatal("should be able to parse permissions", err)
+	}
+
+	if p != p2 {
+		t.Error("parsed permissions should be identical", p, p2)
+	}
+
+	if p2, _ = parsePermissions("0640", "0", "0"); p2.Octal() != "0640" {
+		t.Error("incorrect octal mode", p2.Octal())
+	}
+
+	for _, invalid := range [][]string{{"", "0", "0"}, {"0999", "0", "0"}, {"17777", "0", "0"}, {"0644", "a", "0"}, {"0644", "0", ""}} {
+		if _, err = parsePermissions(invalid[0], invalid[1], invalid[2]); err == nil {
+			t.Error("should not be able to parse invalid permissions", invalid)
+		}
+	}
+}
+
+func TestEntryPermissions(t *testing.T) {
+	e := Entry{path: "test", lastMod: time.Unix(1234, 0), size: 4, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"}
+
+	if _, exists := e.Permissions(); exists {
+		t.Error("permissions should not be recorded by default")
+	}
+
+	e.perms = Permissions{Mode: 0644, UID: 1000, GID: 1000}
+	e.hasPerms = true
+
+	if !strings.HasSuffix(e.AsCsv(), ",0644,1000,1000,,,,,") {
+		t.Error("permissions should be in CSV", e.AsCsv())
+	}
+
+	if !strings.Contains(e.String(), "-rw-r--r-- 1000:1000") {
+		t.Error("permissions should be output", e)
+	}
+}
+
+func TestXattrDigest(t *testing.T) {
+	empty := xattrDigest(map[string][]byte{})
+
+	if empty == "" {
+		t.Error("files without extended attributes should have a digest")
+	}
+
+	one := xattrDigest(map[string][]byte{"user.a": []byte("1"), "user.b": []byte("2")})
+	two := xattrDigest(map[string][]byte{"user.b": []byte("2"), "user.a": []byte("1")})
+
+	if one != two {
+		t.Error("digest should not depend on attribute order", one, two)
+	}
+
+	if one == empty {
+		t.Error("digest should change with attributes")
+	}
+
+	// moving bytes between the name and value must change the digest
+	if xattrDigest(map[string][]byte{"user.a1": []byte("")}) == xattrDigest(map[string][]byte{"user.a": []byte("1")}) {
+		t.Error("digest should distinguish names and values")
+	}
+
+	e := Entry{path: "test", lastMod: time.Unix(1234, 0), size: 4, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"}
+
+	if _, exists := e.Xattrs(); exists {
+		t.Error("extended attributes should not be recorded by default")
+	}
+
+	e.xattrs = one
+
+	if !strings.HasSuffix(e.AsCsv(), ","+one+",,,,") || !strings.Contains(e.String(), "xattrs: "+one) {
+		t.Error("extended attributes should be output", e.AsCsv(), e)
+	}
+}
+
+func TestXattrNames(t *testing.T) {
+	joined := joinXattrNames(map[string][]byte{"user.b": nil, "user.a/%": nil})
+
+	if joined != "user.a%2F%25/user.b" {
+		t.Error("names should be sorted and escaped, not", joined)
+	}
+
+	names, err := splitXattrNames(joined)
+
+	if (err != nil) || (len(names) != 2) || (names[0] != "user.a/%") || (names[1] != "user.b") {
+		t.Error("names should be unescaped", names, err)
+	}
+
+	if names, err = splitXattrNames(""); (err != nil) || (names != nil) {
+		t.Error("no names should be nil", names, err)
+	}
+
+	if _, err = splitXattrNames("user.%zz"); err == nil {
+		t.Error("should not split invalid names")
+	}
+
+	e := Entry{path: "test", lastMod: time.Unix(1234, 0), size: 4, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg", xattrNames: joined}
+
+	if !strings.HasSuffix(e.AsCsv(), ","+joined) || (len(e.XattrNames()) != 2) {
+		t.Error("extended attribute names should be output", e.AsCsv(), e.XattrNames())
+	}
+}
+
+func TestEntryStatus(t *testing.T) {
+	dir := test.SetupOsFs(t)
+	info := test.MakeFile(t, dir+"/test", "test", 0644)
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+dir+"\nbaseName: test")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+	idx, _ := New(&cfg)
+
+	e, err := idx.BuildEntry(dir+"/test", info, sha256.New())
+
+	if err != nil {
+		t.Fatal("cannot build entry", err)
+	}
+
+	ctime, inode, exists := e.Status()
+
+	if runtime.GOOS == "windows" {
+		if exists {
+			t.Error("status should not be recorded on Windows")
+		}
+		return
+	}
+
+	if !exists || ctime.IsZero() || (inode == 0) {
+		t.Fatal("status should be recorded", e)
+	}
+
+	if e.StatusChanged(info) {
+		t.Error("status should not change")
+	}
+
+	if !strings.HasSuffix(e.AsCsv(), fmt.Sprintf(",%d,%d,", ctime.Unix(), inode)) {
+		t.Error("status should be in CSV", e.AsCsv())
+	}
+
+	e.ctime = ctime.Add(-time.Minute)
+
+	if !e.StatusChanged(info) {
+		t.Error("status should change with ctime")
+	}
+
+	e.ctime = ctime
+	e.inode++
+
+	if !e.StatusChanged(info) {
+		t.Error("status should change with inode")
+	}
+
+	// the in-memory file system has no status
+	memInfo := &memInfo{info}
+
+	if e.StatusChanged(memInfo) {
+		t.Error("status should not change without a status")
+	}
+
+	e.ctime = time.Time{}
+
+	if e.StatusChanged(info) {
+		t.Error("status should not change without a recorded status")
+	}
+}
+
+// hides the underlying Stat_t
+type memInfo struct {
+	os.FileInfo
+}
+
+func (m *memInfo) Sys() any {
+	return nil
+}
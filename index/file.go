@@ -3,8 +3,11 @@ package index
 import (
 	"bufio"
 	"compress/gzip"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
@@ -17,12 +20,24 @@ import (
 	"github.com/hpresnall/yabrc/file"
 )
 
+// marks the start of a versioned index; v1 indexes start with the root path
+const formatMagic = "yabrc-index"
+
+// the version written by Store(); Load() can read this and all earlier versions
+const formatVersion = 2
+
+// replaces the 'path' field when paths are percent-encoded; csv.Reader loads '\r\n' as '\n', so '\r' and '%' are
+// escaped to preserve paths exactly
+const escapedPathField = "escapedPath"
+
+var pathEscaper = strings.NewReplacer("%", "%25", "\r", "%0D")
+
 // Load loads the index defined by the given Config plus an (optional) identifier extension (e.g. _current, _known, etc).
 func Load(config *config.Config, ext string) (*Index, error) {
 	idx, err := New(config)
 
 	if err != nil {
-		return idx, nil
+		return idx, err
 	}
 
 	file := idx.GetFile(ext)
@@ -59,9 +74,21 @@ func load(idx *Index, path string) error {
 
 	defer gz.Close()
 
+	r := bufio.NewReader(gz)
+
+	// v1 indexes have no version marker
+	if magic, _ := r.Peek(len(formatMagic) + 1); string(magic) == formatMagic+"," {
+		return loadV2(idx, r)
+	}
+
+	return loadV1(idx, r)
+}
+
+// loadV1 loads the original, unversioned format: a 'root,timestamp[,hash]' header followed by unquoted Entries.
+func loadV1(idx *Index, in io.Reader) error {
 	// use Scanner rather than csv.Reader
 	// the latter does not skip blank lines or have any mechanism to tell you that fields are missing other than errors
-	r := bufio.NewScanner(gz)
+	r := bufio.NewScanner(in)
 	r.Split(bufio.ScanLines)
 
 	readHeader := false
@@ -106,6 +133,17 @@ func load(idx *Index, path string) error {
 
 			idx.timestamp = time.Unix(rawTime, 0)
 
+			// indexes without a hash algorithm were all created with SHA256
+			idx.hasher = SHA256
+
+			if len(fields) > 2 {
+				idx.hasher, err = GetHasher(fields[2])
+
+				if err != nil {
+					return fmt.Errorf("%d: header '%s' has an invalid hash: %v", n, r.Text(), err)
+				}
+			}
+
 			readHeader = true
 			continue
 		}
@@ -154,6 +192,279 @@ func load(idx *Index, path string) error {
 	return nil
 }
 
+// loadV2 loads the versioned format written by Store().
+// The file starts with the format marker, followed by 'key,value' metadata records. A 'fields' record lists the
+// Entry columns and ends the metadata. All remaining records are Entries. Paths are quoted as needed by encoding/csv.
+func loadV2(idx *Index, in io.Reader) error {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1 // metadata records have a variable number of fields
+
+	record, err := r.Read()
+
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(record[1])
+
+	if (err != nil) || (version < 2) || (version > formatVersion) {
+		return fmt.Errorf("unsupported index format version '%s'", record[1])
+	}
+
+	// reset so root and timestamp can be validated
+	idx.timestamp = time.Time{}
+	idx.hasher = nil
+
+	var columns map[string]int
+
+	for columns == nil {
+		record, err = r.Read()
+
+		if err == io.EOF {
+			return errors.New("index has no 'fields' record")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if record[0] == "fields" {
+			columns, err = parseColumns(record[1:])
+
+			if err != nil {
+				return fmt.Errorf("%d: %v", line, err)
+			}
+
+			continue
+		}
+
+		if len(record) != 2 {
+			return fmt.Errorf("%d: metadata %q must be a key and a value", line, record)
+		}
+
+		if err = idx.setMetadata(record[0], record[1]); err != nil {
+			return fmt.Errorf("%d: %v", line, err)
+		}
+	}
+
+	if idx.hasher == nil {
+		return errors.New("index has no 'hash' metadata")
+	}
+
+	if idx.timestamp.IsZero() {
+		return errors.New("index has no 'timestamp' metadata")
+	}
+
+	for {
+		record, err = r.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		line, _ := r.FieldPos(0)
+
+		if err != nil {
+			// csv.Reader can continue after parse errors
+			var parseErr *csv.ParseError
+
+			if errors.As(err, &parseErr) {
+				log.WARN.Printf("%d: skipping invalid line: %v", line, err)
+				continue
+			}
+
+			return err
+		}
+
+		entry, err := parseEntry(record, columns)
+
+		if err != nil {
+			log.WARN.Printf("%d: skipping line %q; %v", line, record, err)
+			continue
+		}
+
+		idx.data[entry.path] = entry
+
+		log.TRACE.Printf("%v: added %v\n", idx, entry)
+	}
+
+	return nil
+}
+
+// setMetadata sets the Index's metadata from a single header record.
+func (idx *Index) setMetadata(key string, value string) error {
+	switch key {
+	case "root":
+		if (value != idx.Config().Root()) && (value != idx.rootWithSlash) {
+			return fmt.Errorf("root '%s' must match Config.Root '%s'", value, idx.Config().Root())
+		}
+	case "timestamp":
+		rawTime, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			return fmt.Errorf("timestamp '%s' must be an integer", value)
+		}
+
+		idx.timestamp = time.Unix(rawTime, 0)
+	case "hash":
+		hasher, err := GetHasher(value)
+
+		if err != nil {
+			return err
+		}
+
+		idx.hasher = hasher
+	case "version":
+		idx.version = value
+	case "hostname":
+		idx.hostname = value
+	case "position":
+		idx.position = value
+	case "baseName":
+		if value != idx.Config().BaseName() {
+			log.DEBUG.Printf("index baseName '%s' does not match Config.BaseName '%s'", value, idx.Config().BaseName())
+		}
+	default:
+		// allow newer indexes with additional metadata to be read
+		log.DEBUG.Printf("ignoring unknown index metadata '%s'", key)
+	}
+
+	return nil
+}
+
+// parseColumns maps each Entry field name to its column in the index.
+func parseColumns(fields []string) (map[string]int, error) {
+	columns := make(map[string]int, len(fields))
+
+	for i, field := range fields {
+		columns[field] = i
+	}
+
+	if i, escaped := columns[escapedPathField]; escaped {
+		columns["path"] = i
+	}
+
+	for _, required := range []string{"path", "lastMod", "size", "hash"} {
+		if _, exists := columns[required]; !exists {
+			return nil, fmt.Errorf("fields %q must include '%s'", fields, required)
+		}
+	}
+
+	return columns, nil
+}
+
+// parseEntry creates an Entry from a record using the given columns.
+func parseEntry(record []string, columns map[string]int) (Entry, error) {
+	var e Entry
+
+	value := func(field string) string {
+		i, exists := columns[field]
+
+		if !exists || (i >= len(record)) {
+			return ""
+		}
+
+		return record[i]
+	}
+
+	rawPath := value("path")
+
+	if _, escaped := columns[escapedPathField]; escaped {
+		var err error
+
+		if rawPath, err = url.PathUnescape(rawPath); err != nil {
+			return e, fmt.Errorf("path '%s' is not escaped correctly", rawPath)
+		}
+	}
+
+	e.path = norm.NFC.String(rawPath) // normalize paths that contain Unicode combining characters to a single character
+
+	if e.path == "" {
+		return e, errors.New("path cannot be empty")
+	}
+
+	e.err = value("error")
+
+	// regular files are stored with an empty type
+	if e.fileType = value("type"); e.fileType == TypeFile {
+		e.fileType = ""
+	}
+
+	e.target = value("target")
+
+	// optional; only recorded if the Config enables it
+	if mode := value("mode"); mode != "" {
+		perms, err := parsePermissions(mode, value("uid"), value("gid"))
+
+		if err != nil {
+			return e, err
+		}
+
+		e.perms = perms
+		e.hasPerms = true
+	}
+
+	e.xattrs = value("xattrs")
+
+	// optional; only recorded if the Config enables it
+	if _, err := splitXattrNames(value("xattrNames")); err != nil {
+		return e, err
+	}
+
+	e.xattrNames = value("xattrNames")
+	e.hardLink = value("hardLink")
+
+	// optional; only recorded on Unix
+	if ctime := value("ctime"); ctime != "" {
+		rawTime, err := strconv.ParseInt(ctime, 10, 64)
+
+		if err != nil {
+			return e, fmt.Errorf("ctime '%s' must be a Unix time value", ctime)
+		}
+
+		e.ctime = time.Unix(rawTime, 0)
+
+		if e.inode, err = strconv.ParseUint(value("inode"), 10, 64); err != nil {
+			return e, fmt.Errorf("inode '%s' must be an unsigned integer", value("inode"))
+		}
+	}
+
+	// error Entries may not have a time
+	if lastMod := value("lastMod"); (lastMod != "") || !e.IsError() {
+		rawTime, err := strconv.ParseInt(lastMod, 10, 64)
+
+		if err != nil {
+			return e, fmt.Errorf("lastMod '%s' must be a Unix time value", lastMod)
+		}
+
+		e.lastMod = time.Unix(rawTime, 0)
+	}
+
+	var err error
+	e.size, err = strconv.ParseInt(value("size"), 10, 64)
+
+	if err != nil {
+		return e, fmt.Errorf("size '%s' must be an integer", value("size"))
+	}
+
+	e.hash = value("hash")
+
+	// optional; older indexes do not record when files were verified
+	if verified := value("verified"); verified != "" {
+		rawTime, err := strconv.ParseInt(verified, 10, 64)
+
+		if err != nil {
+			return e, fmt.Errorf("verified '%s' must be a Unix time value", verified)
+		}
+
+		e.verified = time.Unix(rawTime, 0)
+	}
+
+	return e, nil
+}
+
 // Store writes the index to the file system with the given extension.
 func (idx *Index) Store(ext string) error {
 	if len(idx.data) == 0 {
@@ -181,31 +492,43 @@ func (idx *Index) Store(ext string) error {
 	// gzip the file to save space and for minor obfuscation / edit protection
 	gz := gzip.NewWriter(out)
 
-	// not using csv.Writer since data needs to be converted to strings anyway, Sprintf is easier
+	// csv.Writer quotes paths containing commas, quotes, newlines or leading spaces
+	w := csv.NewWriter(gz)
+
+	// format marker, then metadata, then the list of fields, followed by CSV output for each Entry
+	header := [][]string{
+		{formatMagic, strconv.Itoa(formatVersion)},
+		{"root", idx.Config().Root()},
+		{"timestamp", strconv.FormatInt(idx.timestamp.Unix(), 10)},
+		{"hash", idx.hasher.Name()},
+		{"version", idx.version},
+		{"hostname", idx.hostname},
+		{"baseName", idx.Config().BaseName()},
+	}
+
+	if idx.position != "" {
+		header = append(header, []string{"position", idx.position})
+	}
 
-	// format is root,time on its own line, followed by CSV output for each Entry
-	_, err = gz.Write([]byte(fmt.Sprintf("%s,%d\n", idx.Config().Root(), idx.timestamp.Unix())))
+	header = append(header, append([]string{"fields", escapedPathField}, entryFields[1:]...))
 
-	if err != nil {
+	if err = w.WriteAll(header); err != nil {
 		return fmt.Errorf("cannot save index to '%s': %v", indexFile, err)
 	}
 
 	for _, entry := range idx.data {
-		csv := entry.AsCsv()
+		record := entry.record()
+		record[0] = pathEscaper.Replace(record[0])
 
-		log.TRACE.Println("writing", csv)
+		log.TRACE.Printf("writing %q", record)
 
-		_, err = gz.Write([]byte(csv))
-
-		if err != nil {
+		if err = w.Write(record); err != nil {
 			return fmt.Errorf("cannot save index to '%s': %v", indexFile, err)
 		}
+	}
 
-		_, err = gz.Write([]byte("\n"))
-
-		if err != nil {
-			return fmt.Errorf("cannot save index to '%s': %v", indexFile, err)
-		}
+	if w.Flush(); w.Error() != nil {
+		return fmt.Errorf("cannot save index to '%s': %v", indexFile, w.Error())
 	}
 
 	if err = gz.Flush(); err != nil {
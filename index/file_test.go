@@ -3,8 +3,11 @@ package index
 import (
 	"compress/gzip"
 	"fmt"
+	"io/fs"
+	"os"
 	"path"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 
 	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/test"
 )
 
 func TestLoadMissingIndex(t *testing.T) {
@@ -25,6 +29,15 @@ func TestLoadMissingIndex(t *testing.T) {
 	}
 }
 
+func TestLoadUnknownHash(t *testing.T) {
+	config := config.ForTest(t)
+	config.SetHash("md5")
+
+	if _, err := Load(&config, "_test"); err == nil {
+		t.Error("should not be able to load index with an unknown hash")
+	}
+}
+
 func TestStoreOnBadFs(t *testing.T) {
 	idx := ForTest(t)
 
@@ -131,6 +144,67 @@ func TestStoreAndLoad(t *testing.T) {
 	}
 }
 
+func TestStoreAndLoadHash(t *testing.T) {
+	cfg, err := config.FromString(t, "root: testRoot\nbaseName: testBaseName\nsavePath: testSavePath\nhash: sha1")
+
+	if err != nil {
+		t.Fatal("should be able to load config", err)
+	}
+
+	idx, err := New(&cfg)
+
+	if err != nil {
+		t.Fatal("should be able to create index", err)
+	}
+
+	if idx.Hasher() != SHA1 {
+		t.Fatal("index should use sha1, not", idx.Hasher())
+	}
+
+	err = idx.AddEntry(Entry{path: "test", lastMod: time.Now(), size: 1, hash: "qUqP5cyxm6YcTAhz05Hph5gvu9M"})
+
+	if err != nil {
+		t.Fatal("should be able to add entry", err)
+	}
+
+	if err = idx.Store("_test"); err != nil {
+		t.Fatal("should be able to store index", err)
+	}
+
+	// load with the default algorithm; the stored algorithm should be used
+	cfg.SetHash("")
+
+	idx2, err := Load(&cfg, "_test")
+
+	if err != nil {
+		t.Fatal("should be able to load index", err)
+	}
+
+	if idx2.Hasher() != SHA1 {
+		t.Error("loaded index should use sha1, not", idx2.Hasher())
+	}
+}
+
+func TestLoadNoHash(t *testing.T) {
+	idx, err := fromString(t, fmt.Sprintf("testRoot,%d", time.Now().Unix()))
+
+	if err != nil {
+		t.Fatal("should be able to load Index without a hash", err)
+	}
+
+	if idx.Hasher() != SHA256 {
+		t.Error("Index without a hash should use sha256, not", idx.Hasher())
+	}
+}
+
+func TestLoadBadHash(t *testing.T) {
+	_, err := fromString(t, fmt.Sprintf("testRoot,%d,md5", time.Now().Unix()))
+
+	if err == nil {
+		t.Error("should not be able to load Index with an unknown hash")
+	}
+}
+
 func TestLoadMissing(t *testing.T) {
 	config := config.ForTest(t)
 	_, err := Load(&config, "missing")
@@ -240,10 +314,10 @@ func fromString(t *testing.T, indexString string) (*Index, error) {
 		return &Index{}, err
 	}
 
-	err = gz.Flush()
+	err = gz.Close()
 
 	if err != nil {
-		t.Fatal("could not flush Index save file", err)
+		t.Fatal("could not close gzip writer", err)
 	}
 
 	err = out.Close()
@@ -256,3 +330,438 @@ func fromString(t *testing.T, indexString string) (*Index, error) {
 
 	return Load(&config, "test")
 }
+
+func TestLoadUnescapedPaths(t *testing.T) {
+	// indexes stored before paths were escaped use the 'path' field as is
+	data := `yabrc-index,2
+root,testRoot
+timestamp,1234
+hash,sha256
+fields,path,lastMod,size,hash
+100%25,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg`
+
+	idx, err := fromString(t, data)
+
+	if err != nil {
+		t.Fatal("should be able to load index", err)
+	}
+
+	if _, exists := idx.Get("100%25"); !exists {
+		t.Error("path should not be unescaped", idx.data)
+	}
+
+	data = strings.Replace(data, "fields,path", "fields,"+escapedPathField, 1) + "\nbad%zz,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"
+
+	if idx, err = fromString(t, data); err != nil {
+		t.Fatal("should be able to load index", err)
+	}
+
+	if _, exists := idx.Get("100%"); !exists || (idx.Size() != 1) {
+		t.Error("path should be unescaped and invalid paths skipped", idx.data)
+	}
+}
+
+func TestStoreAndLoadSpecialPaths(t *testing.T) {
+	idx := ForTest(t)
+	idx.version = "testVersion"
+	idx.hostname = "testHost"
+
+	paths := []string{"comma,path", " leading space", "trailing space ", "new\nline", "carriage\rreturn", "windows\r\nline", "percent%0Dpath", "100%", "quote\"path", "\"quoted\"", "yabrc-index,2", "fields,path"}
+
+	for _, p := range paths {
+		if err := idx.AddEntry(Entry{path: p, lastMod: time.Now(), size: 1, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"}); err != nil {
+			t.Fatal("should be able to add entry", err)
+		}
+	}
+
+	if err := idx.Store("_test"); err != nil {
+		t.Fatal("should be able to store index", err)
+	}
+
+	idx2, err := Load(idx.Config(), "_test")
+
+	if err != nil {
+		t.Fatal("should be able to load index", err)
+	}
+
+	if idx2.Size() != len(paths) {
+		t.Fatalf("loaded index should have %d entries, not %d: %v", len(paths), idx2.Size(), idx2.data)
+	}
+
+	for _, p := range paths {
+		e, exists := idx2.Get(p)
+
+		if !exists {
+			t.Errorf("loaded index should contain %q", p)
+			continue
+		}
+
+		if e.Path() != p {
+			t.Errorf("loaded path %q should be %q", e.Path(), p)
+		}
+	}
+
+	if idx2.Version() != "testVersion" {
+		t.Error("version should be 'testVersion', not", idx2.Version())
+	}
+
+	if idx2.Hostname() != "testHost" {
+		t.Error("hostname should be 'testHost', not", idx2.Hostname())
+	}
+
+	if idx2.Timestamp() != idx.Timestamp() {
+		t.Error("timestamps should match", idx.Timestamp(), idx2.Timestamp())
+	}
+}
+
+func TestLoadV2(t *testing.T) {
+	// fields in a different order, extra fields & metadata and bad entries should all be handled
+	data := `yabrc-index,2
+root,testRoot
+timestamp,1234
+hash,sha256
+extra,ignored
+fields,size,hash,extra,lastMod,path
+1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x,1,good
+1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x,1," spaced, path"
+1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x,1,
+1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x,bad,bad_time
+bad,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x,1,bad_size
+1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x,1,bad"quote
+1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg`
+
+	idx, err := fromString(t, data)
+
+	if err != nil {
+		t.Fatal("should be able to load Index with corrupt Entries", err)
+	}
+
+	if idx.Size() != 2 {
+		t.Error("Index should have 2 Entries", idx.Size(), idx.data)
+	}
+
+	if _, exists := idx.Get(" spaced, path"); !exists {
+		t.Error("Index should have an Entry for quoted path", idx.data)
+	}
+
+	if idx.Timestamp().Unix() != 1234 {
+		t.Error("timestamp should be 1234, not", idx.Timestamp().Unix())
+	}
+
+	if idx.Position() != "" {
+		t.Error("complete Index should not have a position", idx.Position())
+	}
+}
+
+func TestStoreAndLoadErrors(t *testing.T) {
+	idx := ForTest(t)
+	root := idx.Config().Root()
+
+	test.MakeFile(t, root+"/test", "test", 0644)
+	info, _ := file.GetFs().Stat(root + "/test")
+
+	entries := map[string]os.FileInfo{"test": info, "missing": nil}
+
+	for path, info := range entries {
+		e, err := idx.BuildErrorEntry(root+"/"+path, info, fs.ErrPermission)
+
+		if err != nil {
+			t.Fatal("should be able to build error entry", err)
+		}
+
+		if err = idx.AddEntry(e); err != nil {
+			t.Fatal("should be able to add error entry", err)
+		}
+	}
+
+	if _, err := idx.BuildErrorEntry("wrongRoot/test", info, fs.ErrPermission); err == nil {
+		t.Error("should not be able to build error entry outside of root")
+	}
+
+	if err := idx.Store("_errors"); err != nil {
+		t.Fatal("should be able to store Index", err)
+	}
+
+	idx2, err := Load(idx.Config(), "_errors")
+
+	if err != nil {
+		t.Fatal("should be able to load Index", err)
+	}
+
+	if idx2.Size() != 2 {
+		t.Fatal("Index should have 2 Entries", idx2.data)
+	}
+
+	for path := range entries {
+		e, _ := idx.Get(path)
+		e2, _ := idx2.Get(path)
+
+		if !e2.IsError() || (e2.Error() != ErrorPermission) || !e2.LastMod().Equal(e.LastMod().Truncate(time.Second)) || (e2.Size() != e.Size()) {
+			t.Error("loaded error Entry should be identical to stored Entry", e, e2)
+		}
+	}
+}
+
+func TestStoreAndLoadEmpty(t *testing.T) {
+	idx := ForTest(t)
+	idx.Config().SetRecordEmpty(true)
+	root := idx.Config().Root()
+
+	test.MakeDir(t, root+"/dir")
+	test.MakeFile(t, root+"/dir/empty", "", 0644)
+
+	for _, path := range []string{"dir", "dir/empty"} {
+		info, _ := file.GetFs().Stat(root + "/" + path)
+
+		if err := idx.Add(root+"/"+path, info); err != nil {
+			t.Fatal("should be able to add", path, err)
+		}
+	}
+
+	if err := idx.Store("_empty"); err != nil {
+		t.Fatal("should be able to store Index", err)
+	}
+
+	idx2, err := Load(idx.Config(), "_empty")
+
+	if err != nil {
+		t.Fatal("should be able to load Index", err)
+	}
+
+	if idx2.Size() != 2 {
+		t.Fatal("Index should have 2 Entries", idx2.data)
+	}
+
+	if dir, _ := idx2.Get("dir"); !dir.IsDir() {
+		t.Error("loaded Entry should be a directory", dir)
+	}
+
+	if empty, _ := idx2.Get("dir/empty"); !empty.IsFile() || (empty.Size() != 0) || (empty.Hash() == "") {
+		t.Error("loaded Entry should be a zero byte file", empty)
+	}
+
+	// explicit file type is the same as empty
+	data := `yabrc-index,2
+root,testRoot
+timestamp,1234
+hash,sha256
+fields,path,lastMod,size,hash,verified,error,type
+test,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,,,file`
+
+	idx3, err := fromString(t, data)
+
+	if err != nil {
+		t.Fatal("should be able to load Index with explicit file type", err)
+	}
+
+	if e, _ := idx3.Get("test"); !e.IsFile() {
+		t.Error("Entry should be a file", e)
+	}
+}
+
+func TestStoreAndLoadSymlink(t *testing.T) {
+	idx := ForTest(t)
+	e := Entry{path: "link", lastMod: time.Unix(1234, 0), fileType: TypeSymlink, target: "../target, with comma"}
+
+	if err := idx.AddEntry(e); err != nil {
+		t.Fatal("should be able to add symlink entry", err)
+	}
+
+	if err := idx.Store("_symlink"); err != nil {
+		t.Fatal("should be able to store Index", err)
+	}
+
+	idx2, err := Load(idx.Config(), "_symlink")
+
+	if err != nil {
+		t.Fatal("should be able to load Index", err)
+	}
+
+	if e2, _ := idx2.Get("link"); !e2.IsSymlink() || (e2.Target() != e.Target()) || !e2.LastMod().Equal(e.LastMod()) {
+		t.Error("loaded symlink Entry should be identical to stored Entry", e, e2)
+	}
+
+	if !strings.Contains(idx2.StringWithEntries(), `"target": "../target, with comma"`) {
+		t.Error("target should be in JSON", idx2.StringWithEntries())
+	}
+}
+
+func TestLoadV2Verified(t *testing.T) {
+	data := `yabrc-index,2
+root,testRoot
+timestamp,1234
+hash,sha256
+fields,path,lastMod,size,hash,verified
+verified,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,5678
+unknown,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,
+bad,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,x`
+
+	idx, err := fromString(t, data)
+
+	if err != nil {
+		t.Fatal("should be able to load Index", err)
+	}
+
+	if idx.Size() != 2 {
+		t.Error("Index should have 2 Entries", idx.Size(), idx.data)
+	}
+
+	if e, _ := idx.Get("verified"); e.Verified().Unix() != 5678 {
+		t.Error("verified should be 5678, not", e.Verified().Unix())
+	}
+
+	if e, _ := idx.Get("unknown"); !e.Verified().IsZero() {
+		t.Error("verified should be unknown, not", e.Verified())
+	}
+}
+
+func TestStoreAndLoadPosition(t *testing.T) {
+	idx := ForTest(t)
+	idx.SetPosition("test")
+
+	if err := idx.AddEntry(Entry{path: "test", lastMod: time.Now(), size: 1, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg", verified: time.Unix(5678, 0)}); err != nil {
+		t.Fatal("should be able to add entry", err)
+	}
+
+	if err := idx.Store("_partial"); err != nil {
+		t.Fatal("should be able to store Index", err)
+	}
+
+	idx2, err := Load(idx.Config(), "_partial")
+
+	if err != nil {
+		t.Fatal("should be able to load Index", err)
+	}
+
+	if idx2.Position() != idx.Position() {
+		t.Errorf("position should be '%s', not '%s'", idx.Position(), idx2.Position())
+	}
+
+	if e, _ := idx2.Get("test"); e.Verified().Unix() != 5678 {
+		t.Error("verified should be 5678, not", e.Verified().Unix())
+	}
+}
+
+func TestLoadV2BadHeader(t *testing.T) {
+	valid := map[string]string{
+		"version":   "yabrc-index,2",
+		"root":      "root,testRoot",
+		"timestamp": "timestamp,1234",
+		"hash":      "hash,sha256",
+		"fields":    "fields,path,lastMod,size,hash",
+	}
+	order := []string{"version", "root", "timestamp", "hash", "fields"}
+
+	invalid := map[string][]string{
+		"version":   {"yabrc-index,x", "yabrc-index,1", "yabrc-index,99"},
+		"root":      {"root,wrongRoot", "root,testRoot,extra"},
+		"timestamp": {"timestamp,x", ""},
+		"hash":      {"hash,md5", ""},
+		"fields":    {"fields,path,lastMod,size", ""},
+	}
+
+	for _, key := range order {
+		for _, bad := range invalid[key] {
+			lines := make([]string, 0, len(order))
+
+			for _, k := range order {
+				if k == key {
+					lines = append(lines, bad)
+				} else {
+					lines = append(lines, valid[k])
+				}
+			}
+
+			data := strings.Join(lines, "\n")
+
+			if _, err := fromString(t, data); err == nil {
+				t.Errorf("should not be able to load Index with invalid header %q", data)
+			}
+		}
+	}
+}
+
+func TestStoreAndLoadMetadata(t *testing.T) {
+	idx := ForTest(t)
+	e := Entry{path: "test", lastMod: time.Unix(1234, 0), size: 4, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg",
+		perms: Permissions{Mode: 0750 | os.ModeSetgid, UID: 1000, GID: 100}, hasPerms: true, xattrs: xattrDigest(map[string][]byte{"user.test": []byte("1")}),
+		xattrNames: joinXattrNames(map[string][]byte{"user.test": []byte("1"), "user.a/b,c": nil}), hardLink: "other, with comma", ctime: time.Unix(5678, 0), inode: 1 << 40}
+
+	if err := idx.AddEntry(e); err != nil {
+		t.Fatal("should be able to add entry", err)
+	}
+
+	if err := idx.Store("_perms"); err != nil {
+		t.Fatal("should be able to store Index", err)
+	}
+
+	idx2, err := Load(idx.Config(), "_perms")
+
+	if err != nil {
+		t.Fatal("should be able to load Index", err)
+	}
+
+	e2, _ := idx2.Get("test")
+
+	if p, exists := e2.Permissions(); !exists || (p != e.perms) {
+		t.Error("loaded permissions should be identical to stored permissions", e, e2)
+	}
+
+	if !strings.Contains(idx2.StringWithEntries(), `"mode": "2750"`) {
+		t.Error("mode should be in JSON", idx2.StringWithEntries())
+	}
+
+	if x, exists := e2.Xattrs(); !exists || (x != e.xattrs) {
+		t.Error("loaded extended attributes should be identical to stored attributes", e, e2)
+	}
+
+	if names := e2.XattrNames(); (len(names) != 2) || (names[0] != "user.a/b,c") || (names[1] != "user.test") {
+		t.Error("loaded extended attribute names should be identical to stored names", names)
+	}
+
+	if !strings.Contains(idx2.StringWithEntries(), `"xattrNames": ["user.a/b,c", "user.test"]`) {
+		t.Error("extended attribute names should be in JSON", idx2.StringWithEntries())
+	}
+
+	if e2.HardLink() != e.hardLink {
+		t.Error("loaded hard link should be identical to stored hard link", e, e2)
+	}
+
+	if ctime, inode, exists := e2.Status(); !exists || !ctime.Equal(e.ctime) || (inode != e.inode) {
+		t.Error("loaded status should be identical to stored status", e, e2)
+	}
+
+	data := `yabrc-index,2
+root,testRoot
+timestamp,1234
+hash,sha256
+fields,path,lastMod,size,hash,verified,error,type,target,mode,uid,gid
+test,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,,,,,0648,0,0`
+
+	idx3, err := fromString(t, data)
+
+	if err != nil {
+		t.Fatal("should skip Entries with invalid mode", err)
+	}
+
+	if _, exists := idx3.Get("test"); exists {
+		t.Error("Entry with invalid mode should be skipped")
+	}
+
+	data = `yabrc-index,2
+root,testRoot
+timestamp,1234
+hash,sha256
+fields,path,lastMod,size,hash,verified,xattrs,xattrNames
+test,1,1,n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg,,digest,user.%zz`
+
+	idx4, err := fromString(t, data)
+
+	if err != nil {
+		t.Fatal("should skip Entries with invalid extended attribute names", err)
+	}
+
+	if _, exists := idx4.Get("test"); exists {
+		t.Error("Entry with invalid extended attribute names should be skipped")
+	}
+}
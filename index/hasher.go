@@ -0,0 +1,92 @@
+package index
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// Hasher defines an algorithm used to hash file contents.
+// Hashers are singletons, so they can be compared with ==.
+type Hasher struct {
+	name string
+	new  func() hash.Hash
+	size int // length of the hash when base64 encoded without padding
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// SHA256 is the default Hasher.
+var SHA256 = &Hasher{name: "sha256", new: sha256.New, size: 43}
+
+// SHA512 is slower than SHA256 on most 32-bit systems but may be faster on 64-bit systems.
+var SHA512 = &Hasher{name: "sha512", new: sha512.New, size: 86}
+
+// SHA1 is cryptographically broken and meant only for interoperability with other tools.
+var SHA1 = &Hasher{name: "sha1", new: sha1.New, size: 27}
+
+// CRC32C is a fast, non-cryptographic checksum. It will detect random bit rot but not tampering.
+var CRC32C = &Hasher{name: "crc32c", new: func() hash.Hash { return crc32.New(castagnoli) }, size: 6}
+
+// DefaultHasher is used when no algorithm is specified.
+var DefaultHasher = SHA256
+
+var hashers = []*Hasher{SHA256, SHA512, SHA1, CRC32C}
+
+// GetHasher returns the Hasher with the given, case insensitive name.
+// An empty name returns the DefaultHasher.
+func GetHasher(name string) (*Hasher, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if name == "" {
+		return DefaultHasher, nil
+	}
+
+	for _, h := range hashers {
+		if h.name == name {
+			return h, nil
+		}
+	}
+
+	names := make([]string, len(hashers))
+
+	for i, h := range hashers {
+		names[i] = h.name
+	}
+
+	return nil, fmt.Errorf("unknown hash algorithm '%s'; must be one of %s", name, strings.Join(names, ", "))
+}
+
+// Name returns the name of the hash algorithm.
+func (h *Hasher) Name() string {
+	return h.name
+}
+
+// New creates a new hash.Hash for this algorithm.
+func (h *Hasher) New() hash.Hash {
+	return h.new()
+}
+
+func (h *Hasher) String() string {
+	return h.name
+}
+
+// isValid returns true if the given base64 encoded hash could have been created by this Hasher.
+func (h *Hasher) isValid(hash string) bool {
+	return len(hash) == h.size
+}
+
+// isValidHash returns true if the given base64 encoded hash could have been created by any Hasher.
+func isValidHash(hash string) bool {
+	for _, h := range hashers {
+		if h.isValid(hash) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,65 @@
+package index
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestGetHasher(t *testing.T) {
+	for _, h := range hashers {
+		found, err := GetHasher(h.Name())
+
+		if err != nil {
+			t.Error("should be able to get hasher", h, err)
+		}
+
+		if found != h {
+			t.Errorf("should get '%s', not '%s'", h, found)
+		}
+	}
+
+	// case and spaces should be ignored
+	h, err := GetHasher(" SHA512 ")
+
+	if err != nil {
+		t.Fatal("should be able to get hasher", err)
+	}
+
+	if h != SHA512 {
+		t.Error("should get sha512, not", h)
+	}
+
+	h, err = GetHasher("")
+
+	if err != nil {
+		t.Fatal("should be able to get default hasher", err)
+	}
+
+	if h != DefaultHasher {
+		t.Error("should get default hasher, not", h)
+	}
+
+	if _, err = GetHasher("md5"); err == nil {
+		t.Error("should not be able to get unknown hasher")
+	}
+}
+
+func TestHasherSize(t *testing.T) {
+	for _, h := range hashers {
+		hash := h.New()
+		hash.Write([]byte("test"))
+		encoded := base64.RawStdEncoding.EncodeToString(hash.Sum(nil))
+
+		if !h.isValid(encoded) {
+			t.Errorf("'%s' hash '%s' should have length %d, not %d", h, encoded, h.size, len(encoded))
+		}
+
+		if !isValidHash(encoded) {
+			t.Errorf("'%s' hash '%s' should be valid", h, encoded)
+		}
+	}
+
+	if isValidHash("hash") {
+		t.Error("short hash should not be valid")
+	}
+}
@@ -2,9 +2,12 @@ package index
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -17,12 +20,19 @@ import (
 	"github.com/hpresnall/yabrc/config"
 )
 
+// Version is the yabrc version stored in new indexes.
+var Version = "unknown"
+
 // Index stores data for all files under a Config's root directory.
 type Index struct {
 	config    *config.Config
+	hasher    *Hasher          // algorithm used to hash all the Entries
 	rootLen   int              // length of Config.root for comparisons
 	timestamp time.Time        // time, in epoch seconds, when the index was buil
 	data      map[string]Entry // the index is a map of the file path to a row of data
+	version   string           // version of yabrc that created the index
+	hostname  string           // host where the index was created
+	position  string           // last path completed by a partial index; empty when complete
 
 	rootWithSlash string
 }
@@ -33,12 +43,24 @@ func New(config *config.Config) (*Index, error) {
 		return nil, errors.New("cannot create an index with a nil Config")
 	}
 
+	hasher, err := GetHasher(config.Hash())
+
+	if err != nil {
+		return nil, err
+	}
+
+	// hostname is informational only; ignore errors
+	hostname, _ := os.Hostname()
+
 	return &Index{
 		config:  config,
+		hasher:  hasher,
 		rootLen: utf8.RuneCountInString(config.Root()) + 1, // add one to rootLen to avoid Entries starting with /
 		// truncate time for Load & Store comparisions since it will only be stored as Unix time
 		timestamp:     time.Now().Truncate(time.Second),
 		data:          make(map[string]Entry),
+		version:       Version,
+		hostname:      hostname,
 		rootWithSlash: config.Root() + "/",
 	}, nil
 }
@@ -52,6 +74,32 @@ func (idx *Index) Timestamp() time.Time {
 	return idx.timestamp
 }
 
+// Hasher returns the algorithm used to hash the Entries in the index.
+func (idx *Index) Hasher() *Hasher {
+	return idx.hasher
+}
+
+// Version returns the version of yabrc that created this index. Empty for older indexes.
+func (idx *Index) Version() string {
+	return idx.version
+}
+
+// Hostname returns the name of the host that created this index. Empty for older indexes.
+func (idx *Index) Hostname() string {
+	return idx.hostname
+}
+
+// Position returns the path, relative to the root, of the last file completed when a partial index was stored.
+// Empty for complete indexes.
+func (idx *Index) Position() string {
+	return idx.position
+}
+
+// SetPosition marks the index as partial, with all files up to and including the given path completed.
+func (idx *Index) SetPosition(path string) {
+	idx.position = path
+}
+
 // Size returns the number of Entries in the index.
 func (idx *Index) Size() int {
 	return len(idx.data)
@@ -59,34 +107,161 @@ func (idx *Index) Size() int {
 
 // Add the given file to the index after parsing it to a new Entry.
 // The given path must include the index's root.
+// Directories and zero byte files are skipped unless the Config records empty files.
 func (idx *Index) Add(path string, info os.FileInfo) error {
-	// skip 0 byte files
-	if (info != nil) && (info.Size() <= 0) {
-		log.DEBUG.Printf("%v: skipped zero byte file '%s'\n", idx, path)
-		return nil
+	var entry Entry
+	var err error
+
+	if (info != nil) && info.IsDir() {
+		if !idx.config.RecordEmpty() {
+			log.DEBUG.Printf("%v: skipped directory '%s'\n", idx, path)
+			return nil
+		}
+
+		entry, err = idx.BuildDirEntry(path, info)
+	} else {
+		if (info != nil) && (info.Size() <= 0) && !idx.config.RecordEmpty() {
+			log.DEBUG.Printf("%v: skipped zero byte file '%s'\n", idx, path)
+			return nil
+		}
+
+		entry, err = idx.BuildEntry(path, info, idx.hasher.New())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	idx.addEntryToMap(entry)
+
+	return nil
+}
+
+// BuildEntry hashes the given file and returns a new Entry for it _without_ adding it to the index.
+// The given path must include the index's root. The hash is reset before use.
+// The hash should be created by the index's Hasher.
+// This function is safe for concurrent use as long as each goroutine uses its own hash.Hash.
+func (idx *Index) BuildEntry(path string, info os.FileInfo, h hash.Hash) (Entry, error) {
+	normalized, err := idx.normalizePath(path)
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	e, err := buildEntry(normalized, info, h)
+
+	if err != nil {
+		return e, err
+	}
+
+	return idx.UpdateMetadata(e, path, info), nil
+}
+
+// BuildErrorEntry returns a new Entry recording that the given file could not be read _without_ adding it to the
+// index. The given path must include the index's root. info may be nil.
+func (idx *Index) BuildErrorEntry(path string, info os.FileInfo, err error) (Entry, error) {
+	path, pathErr := idx.normalizePath(path)
+
+	if pathErr != nil {
+		return Entry{}, pathErr
+	}
+
+	return buildErrorEntry(path, info, err)
+}
+
+// BuildDirEntry returns a new Entry for the given directory _without_ adding it to the index.
+// The given path must include the index's root.
+func (idx *Index) BuildDirEntry(path string, info os.FileInfo) (Entry, error) {
+	normalized, err := idx.normalizePath(path)
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	e, err := buildDirEntry(normalized, info)
+
+	if err != nil {
+		return e, err
+	}
+
+	return idx.UpdateMetadata(e, path, info), nil
+}
+
+// BuildSymlinkEntry returns a new Entry for the given symbolic link _without_ adding it to the index. The target is
+// the link's contents, not the file it points to. The given path must include the index's root.
+func (idx *Index) BuildSymlinkEntry(path string, info os.FileInfo, target string) (Entry, error) {
+	normalized, err := idx.normalizePath(path)
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	e, err := buildSymlinkEntry(normalized, info, target)
+
+	if err != nil {
+		return e, err
 	}
 
+	return idx.UpdateMetadata(e, path, info), nil
+}
+
+// normalizePath converts the path to NFC with / separators and checks that it includes the index's root.
+func (idx *Index) normalizePath(path string) (string, error) {
 	// ensure Windows \ are changed to /
 	path = norm.NFC.String(strings.Replace(path, "\\", "/", -1))
 
 	if !strings.HasPrefix(path, idx.config.Root()) {
-		return fmt.Errorf("%v: path '%s' does not start with root", idx, path)
+		return path, fmt.Errorf("%v: path '%s' does not start with root", idx, path)
 	}
 
-	entry, err := buildEntry(path, info)
+	return path, nil
+}
 
-	if err != nil {
-		return err
+// UpdateMetadata returns a copy of the Entry with the status, plus the mode, ownership and extended attributes if the
+// Config records them, from the given file. Use this when reusing an Entry since metadata changes do not update the
+// modification time. The given path must include the index's root. Only the path's parent directory is used to read
+// the file, along with the name from the FileInfo, so paths normalized by the index can still be read.
+func (idx *Index) UpdateMetadata(entry Entry, path string, info os.FileInfo) Entry {
+	entry.perms, entry.hasPerms = Permissions{}, false
+	entry.xattrs, entry.xattrNames = "", ""
+	entry.ctime, entry.inode = time.Time{}, 0
+
+	if info == nil {
+		return entry
 	}
 
-	idx.addEntryToMap(entry)
+	// only used to detect changed files
+	if entry.IsFile() && !entry.IsError() {
+		entry.ctime, entry.inode, _ = readStatus(info)
+	}
 
-	return nil
+	if idx.config.RecordPermissions() {
+		entry.perms, entry.hasPerms = readPermissions(info)
+	}
+
+	if idx.config.Xattrs() {
+		// the name on disk may not be normalized
+		xattrs, exists, err := readXattrs(filepath.Join(filepath.Dir(path), info.Name()), info)
+
+		if err != nil {
+			// still index the contents; the attributes will not be compared
+			log.WARN.Printf("%v: cannot read extended attributes of '%s': %v\n", idx, path, err)
+		} else if exists {
+			entry.xattrs = xattrDigest(xattrs)
+
+			if idx.config.XattrNames() {
+				entry.xattrNames = joinXattrNames(xattrs)
+			}
+		}
+	}
+
+	return entry
 }
 
 // AddEntry adds the given Entry to the index.
 func (idx *Index) AddEntry(entry Entry) error {
-	if entry.IsValid() {
+	// only regular files have a hash
+	if entry.IsValid() && (entry.IsError() || !entry.IsFile() || idx.hasher.isValid(entry.hash)) {
 		if !strings.HasPrefix(entry.path, idx.rootWithSlash) {
 			// add the entry without changing its path
 			idx.data[entry.path] = entry
@@ -133,7 +308,7 @@ func (idx *Index) ForEach(f func(Entry)) {
 }
 
 func (idx *Index) String() string {
-	return fmt.Sprintf("{root: '%s', timestamp: %s, size: %d}", idx.config.Root(), humanize.Time(idx.Timestamp()), idx.Size())
+	return fmt.Sprintf("{root: '%s', timestamp: %s, size: %d, hash: %s}", idx.config.Root(), humanize.Time(idx.Timestamp()), idx.Size(), idx.hasher)
 }
 
 // StringWithEntries returns the Index as JSON string that contains all of the Entries.
@@ -145,26 +320,88 @@ func (idx *Index) StringWithEntries() string {
 	// very roughly index output + size * entry output
 	buffer.Grow(75 + size*150)
 
-	buffer.WriteString("{\"root\": \"")
-	buffer.WriteString(idx.config.Root())
-	buffer.WriteString("\", \"timestamp\": ")
+	buffer.WriteString("{\"root\": ")
+	buffer.WriteString(jsonString(idx.config.Root()))
+	buffer.WriteString(", \"timestamp\": ")
 	buffer.WriteString(strconv.FormatInt(idx.Timestamp().Unix(), 10))
 	buffer.WriteString(", \"size\": ")
 	buffer.WriteString(strconv.Itoa(size))
+	buffer.WriteString(", \"hash\": \"")
+	buffer.WriteString(idx.hasher.Name())
+	buffer.WriteString("\", \"version\": ")
+	buffer.WriteString(jsonString(idx.version))
+	buffer.WriteString(", \"hostname\": ")
+	buffer.WriteString(jsonString(idx.hostname))
 	buffer.WriteString(", \"entries\": [")
 
 	n := 1
 
 	idx.ForEach(func(e Entry) {
-		buffer.WriteString("{\"path\": \"")
-		buffer.WriteString(e.Path())
-		buffer.WriteString("\", \"lastMod\": ")
+		buffer.WriteString("{\"path\": ")
+		buffer.WriteString(jsonString(e.Path()))
+		buffer.WriteString(", \"lastMod\": ")
 		buffer.WriteString(strconv.FormatInt(e.LastMod().Unix(), 10))
 		buffer.WriteString(", \"size\": ")
 		buffer.WriteString(strconv.FormatInt(e.Size(), 10))
 		buffer.WriteString(", \"hash\": \"")
 		buffer.WriteString(e.Hash())
-		buffer.WriteString("\"}")
+		buffer.WriteString("\", \"verified\": ")
+
+		if e.Verified().IsZero() {
+			buffer.WriteString("null")
+		} else {
+			buffer.WriteString(strconv.FormatInt(e.Verified().Unix(), 10))
+		}
+
+		if !e.IsFile() {
+			buffer.WriteString(", \"type\": \"")
+			buffer.WriteString(e.Type())
+			buffer.WriteString("\"")
+		}
+
+		if e.IsSymlink() {
+			buffer.WriteString(", \"target\": ")
+			buffer.WriteString(jsonString(e.Target()))
+		}
+
+		if perms, exists := e.Permissions(); exists {
+			buffer.WriteString(fmt.Sprintf(", \"mode\": \"%s\", \"uid\": %d, \"gid\": %d", perms.Octal(), perms.UID, perms.GID))
+		}
+
+		if xattrs, exists := e.Xattrs(); exists {
+			buffer.WriteString(", \"xattrs\": \"")
+			buffer.WriteString(xattrs)
+			buffer.WriteString("\"")
+		}
+
+		if names := e.XattrNames(); len(names) > 0 {
+			quoted := make([]string, len(names))
+
+			for i, name := range names {
+				quoted[i] = jsonString(name)
+			}
+
+			buffer.WriteString(", \"xattrNames\": [")
+			buffer.WriteString(strings.Join(quoted, ", "))
+			buffer.WriteString("]")
+		}
+
+		if e.HardLink() != "" {
+			buffer.WriteString(", \"hardLink\": ")
+			buffer.WriteString(jsonString(e.HardLink()))
+		}
+
+		if ctime, inode, exists := e.Status(); exists {
+			buffer.WriteString(fmt.Sprintf(", \"ctime\": %d, \"inode\": %d", ctime.Unix(), inode))
+		}
+
+		if e.IsError() {
+			buffer.WriteString(", \"error\": \"")
+			buffer.WriteString(e.Error())
+			buffer.WriteString("\"")
+		}
+
+		buffer.WriteString("}")
 
 		if n < size {
 			buffer.WriteString(", ")
@@ -177,3 +414,10 @@ func (idx *Index) StringWithEntries() string {
 
 	return buffer.String()
 }
+
+// jsonString quotes and escapes the string for JSON output.
+func jsonString(s string) string {
+	// marshalling a string cannot fail
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -1,11 +1,14 @@
 package index
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/spf13/afero"
 
+	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
 )
 
@@ -17,6 +20,17 @@ func TestNewNilConfig(t *testing.T) {
 	}
 }
 
+func TestNewBadHash(t *testing.T) {
+	cfg := config.ForTest(t)
+	cfg.SetHash("md5")
+
+	_, err := New(&cfg)
+
+	if err == nil {
+		t.Fatal("should not be able to create Index with an unknown hash")
+	}
+}
+
 func TestNew(t *testing.T) {
 	idx := ForTest(t)
 
@@ -35,6 +49,10 @@ func TestNew(t *testing.T) {
 	if idx.Size() != 0 {
 		t.Error("data should be empty")
 	}
+
+	if idx.Hasher() != DefaultHasher {
+		t.Error("hasher should be the default, not", idx.Hasher())
+	}
 }
 
 func TestAdd(t *testing.T) {
@@ -102,6 +120,39 @@ func TestAdd(t *testing.T) {
 	if idx.Size() != 1 {
 		t.Error("data should have 1 Entry")
 	}
+
+	// directories are also skipped by default
+	info, _ = testFs.Stat(root)
+
+	if err = idx.Add(root, info); err != nil {
+		t.Error("cannot add directory", err)
+	}
+
+	if idx.Size() != 1 {
+		t.Error("data should have 1 Entry")
+	}
+
+	idx.Config().SetRecordEmpty(true)
+	info, _ = testFs.Stat(root + "/testzero")
+
+	if err = idx.Add(root+"/testzero", info); err != nil {
+		t.Error("cannot add zero byte Entry", err)
+	}
+
+	testFs.Mkdir(root+"/dir", 0755)
+	info, _ = testFs.Stat(root + "/dir")
+
+	if err = idx.Add(root+"/dir", info); err != nil {
+		t.Error("cannot add directory Entry", err)
+	}
+
+	if e, _ := idx.Get("dir"); !e.IsDir() || (idx.Size() != 3) {
+		t.Error("data should have 3 Entries, including a directory", idx.data)
+	}
+
+	if !strings.Contains(idx.StringWithEntries(), `"type": "dir"`) {
+		t.Error("directory type should be in JSON", idx.StringWithEntries())
+	}
 }
 
 func TestAddBadPath(t *testing.T) {
@@ -193,6 +244,64 @@ func TestAddEntry(t *testing.T) {
 	if err == nil {
 		t.Error("should not be able to add invalid entry")
 	}
+
+	// valid entry from a different Hasher
+	e = Entry{path: idx.Config().Root() + "/test", lastMod: time.Now(), size: 1, hash: "qUqP5cyxm6YcTAhz05Hph5gvu9M"}
+	err = idx.AddEntry(e)
+
+	if err == nil {
+		t.Error("should not be able to add entry with a different hash algorithm")
+	}
+}
+
+func TestStringWithEntries(t *testing.T) {
+	idx := ForTest(t)
+
+	entries := []Entry{
+		{path: `quoted "path"`, lastMod: time.Unix(1, 0), size: 1, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg", verified: time.Unix(1234, 0)},
+		{path: "unverified", lastMod: time.Unix(1, 0), size: 1, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"},
+	}
+
+	for _, e := range entries {
+		if err := idx.AddEntry(e); err != nil {
+			t.Fatal("should be able to add entry", err)
+		}
+	}
+
+	var parsed struct {
+		Root    string `json:"root"`
+		Entries []struct {
+			Path     string `json:"path"`
+			Verified *int64 `json:"verified"`
+		} `json:"entries"`
+	}
+
+	if err := json.Unmarshal([]byte(idx.StringWithEntries()), &parsed); err != nil {
+		t.Fatal("should output valid JSON", err, idx.StringWithEntries())
+	}
+
+	if (parsed.Root != idx.Config().Root()) || (len(parsed.Entries) != 2) {
+		t.Fatal("incorrect JSON", idx.StringWithEntries())
+	}
+
+	for _, e := range parsed.Entries {
+		switch e.Path {
+		case `quoted "path"`:
+			if (e.Verified == nil) || (*e.Verified != 1234) {
+				t.Error("verified should be 1234", e.Verified)
+			}
+		case "unverified":
+			if e.Verified != nil {
+				t.Error("verified should be null, not", *e.Verified)
+			}
+		default:
+			t.Error("unexpected path", e.Path)
+		}
+	}
+
+	if !strings.Contains(entries[1].String(), "verified: unknown") {
+		t.Error("String() should output unknown verified time", entries[1])
+	}
 }
 
 func TestGetNonExistentEntry(t *testing.T) {
@@ -230,3 +339,47 @@ func TestForEach(t *testing.T) {
 		t.Error("should have iterated over 1 entry")
 	}
 }
+
+func TestSetHardLinks(t *testing.T) {
+	idx := ForTest(t)
+
+	for _, path := range []string{"b", "a", "c", "d"} {
+		e := Entry{path: path, lastMod: time.Unix(1234, 0), size: 4, hash: "n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg"}
+
+		if err := idx.AddEntry(e); err != nil {
+			t.Fatal("should be able to add entry", err)
+		}
+	}
+
+	// missing paths are ignored; a group with a single Entry is not linked
+	idx.SetHardLinks([][]string{{"c", "b", "a"}, {"d", "missing"}})
+
+	for path, expected := range map[string]string{"a": "a", "b": "a", "c": "a", "d": ""} {
+		if e, _ := idx.Get(path); e.HardLink() != expected {
+			t.Errorf("'%s' should be linked with '%s', not '%s'", path, expected, e.HardLink())
+		}
+	}
+
+	idx.SetHardLinks([][]string{{"c", "d"}})
+
+	for path, expected := range map[string]string{"a": "", "b": "", "c": "c", "d": "c"} {
+		if e, _ := idx.Get(path); e.HardLink() != expected {
+			t.Errorf("'%s' should be linked with '%s', not '%s'", path, expected, e.HardLink())
+		}
+	}
+
+	e, _ := idx.Get("c")
+	linked, err := idx.BuildHardLinkEntry(idx.Config().Root()+"/e", e)
+
+	if (err != nil) || (linked.Path() != idx.Config().Root()+"/e") || (linked.Hash() != e.Hash()) {
+		t.Error("should be able to build hard link entry", linked, err)
+	}
+
+	if _, err = idx.BuildHardLinkEntry("/other/e", e); err == nil {
+		t.Error("should not be able to build hard link entry outside the root")
+	}
+
+	if _, err = idx.BuildHardLinkEntry(idx.Config().Root()+"/e", Entry{}); err == nil {
+		t.Error("should not be able to build hard link entry from an invalid entry")
+	}
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LinkID identifies the file that multiple hard links point to.
+type LinkID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// BuildHardLinkEntry returns a new Entry for a file hard linked to the file used to build the given Entry _without_
+// adding it to the index or reading the file. Since both paths point to the same data, the hash and all the metadata are
+// copied. The given path must include the index's root.
+func (idx *Index) BuildHardLinkEntry(path string, linked Entry) (Entry, error) {
+	path, err := idx.normalizePath(path)
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if !linked.IsValid() || !linked.IsFile() || linked.IsError() {
+		return Entry{}, fmt.Errorf("%v: cannot link '%s' to invalid entry '%v'", idx, path, linked)
+	}
+
+	linked.path = path
+
+	return linked, nil
+}
+
+// SetHardLinks replaces the hard link groups of all the Entries. Each group lists the relative paths linked to the
+// same file. Paths not in the Index are ignored and groups with fewer than 2 Entries are not recorded, since those
+// files are not linked to anything else in the Index.
+func (idx *Index) SetHardLinks(groups [][]string) {
+	for path, entry := range idx.data {
+		if entry.hardLink != "" {
+			entry.hardLink = ""
+			idx.data[path] = entry
+		}
+	}
+
+	for _, group := range groups {
+		var paths []string
+
+		for _, path := range group {
+			if e, exists := idx.data[path]; exists && e.IsFile() && !e.IsError() {
+				paths = append(paths, path)
+			}
+		}
+
+		if len(paths) < 2 {
+			continue
+		}
+
+		// the first path is stable no matter what order the files were found
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			entry := idx.data[path]
+			entry.hardLink = paths[0]
+			idx.data[path] = entry
+		}
+	}
+}
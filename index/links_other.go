@@ -0,0 +1,11 @@
+//go:build !unix
+// +build !unix
+
+package index
+
+import "os"
+
+// HardLinkID always returns false since inodes are not available outside of Unix.
+func HardLinkID(info os.FileInfo) (LinkID, bool) {
+	return LinkID{}, false
+}
@@ -0,0 +1,21 @@
+//go:build unix
+// +build unix
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// HardLinkID returns the device and inode of the file. Returns false if the file has only one link or the FileInfo
+// does not come from the operating system.
+func HardLinkID(info os.FileInfo) (LinkID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok || (stat.Nlink < 2) {
+		return LinkID{}, false
+	}
+
+	return LinkID{Dev: uint64(stat.Dev), Ino: uint64(stat.Ino)}, true
+}
@@ -0,0 +1,71 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Permissions holds a file's mode bits and ownership. Only recorded on Unix when the Config enables it.
+type Permissions struct {
+	Mode os.FileMode // permission bits, plus the setuid, setgid and sticky bits
+	UID  int
+	GID  int
+}
+
+func (p Permissions) String() string {
+	return fmt.Sprintf("%s %d:%d", p.Mode, p.UID, p.GID)
+}
+
+// Octal returns the mode in the traditional Unix octal format, e.g. '0644' or '4755'.
+func (p Permissions) Octal() string {
+	mode := uint32(p.Mode.Perm())
+
+	if (p.Mode & os.ModeSetuid) != 0 {
+		mode |= 0o4000
+	}
+	if (p.Mode & os.ModeSetgid) != 0 {
+		mode |= 0o2000
+	}
+	if (p.Mode & os.ModeSticky) != 0 {
+		mode |= 0o1000
+	}
+
+	return fmt.Sprintf("%04o", mode)
+}
+
+// parsePermissions is the inverse of Permissions.Octal() plus the uid and gid.
+func parsePermissions(octal string, uid string, gid string) (Permissions, error) {
+	var p Permissions
+
+	mode, err := strconv.ParseUint(octal, 8, 32)
+
+	if (err != nil) || (mode > 0o7777) {
+		return p, fmt.Errorf("mode '%s' must be an octal value", octal)
+	}
+
+	p.Mode = os.FileMode(mode & 0o777)
+
+	if (mode & 0o4000) != 0 {
+		p.Mode |= os.ModeSetuid
+	}
+	if (mode & 0o2000) != 0 {
+		p.Mode |= os.ModeSetgid
+	}
+	if (mode & 0o1000) != 0 {
+		p.Mode |= os.ModeSticky
+	}
+
+	if p.UID, err = strconv.Atoi(uid); err != nil {
+		return p, fmt.Errorf("uid '%s' must be an integer", uid)
+	}
+
+	if p.GID, err = strconv.Atoi(gid); err != nil {
+		return p, fmt.Errorf("gid '%s' must be an integer", gid)
+	}
+
+	return p, nil
+}
+
+// permissionBits are the parts of os.FileMode stored in Permissions
+const permissionBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
@@ -0,0 +1,11 @@
+//go:build !unix
+// +build !unix
+
+package index
+
+import "os"
+
+// readPermissions always returns false since ownership is not available outside of Unix.
+func readPermissions(info os.FileInfo) (Permissions, bool) {
+	return Permissions{}, false
+}
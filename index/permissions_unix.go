@@ -0,0 +1,21 @@
+//go:build unix
+// +build unix
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// readPermissions returns the mode and ownership of the file. Returns false if the FileInfo does not come from the
+// operating system, e.g. from an in-memory file system.
+func readPermissions(info os.FileInfo) (Permissions, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return Permissions{}, false
+	}
+
+	return Permissions{Mode: info.Mode() & permissionBits, UID: int(stat.Uid), GID: int(stat.Gid)}, true
+}
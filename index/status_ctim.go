@@ -0,0 +1,22 @@
+//go:build linux || openbsd || dragonfly || solaris
+// +build linux openbsd dragonfly solaris
+
+package index
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// readStatus returns the file's status change time and inode. Returns false if the FileInfo does not come from the
+// operating system.
+func readStatus(info os.FileInfo) (time.Time, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return time.Time{}, 0, false
+	}
+
+	return time.Unix(stat.Ctim.Unix()), uint64(stat.Ino), true
+}
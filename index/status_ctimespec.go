@@ -0,0 +1,22 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package index
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// readStatus returns the file's status change time and inode. Returns false if the FileInfo does not come from the
+// operating system.
+func readStatus(info os.FileInfo) (time.Time, uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		return time.Time{}, 0, false
+	}
+
+	return time.Unix(stat.Ctimespec.Unix()), uint64(stat.Ino), true
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !openbsd && !dragonfly && !solaris && !darwin && !freebsd && !netbsd
+// +build !linux,!openbsd,!dragonfly,!solaris,!darwin,!freebsd,!netbsd
+
+package index
+
+import (
+	"os"
+	"time"
+)
+
+// readStatus always returns false since status change times and inodes are not available on this platform.
+func readStatus(info os.FileInfo) (time.Time, uint64, bool) {
+	return time.Time{}, 0, false
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// xattrDigest hashes the given extended attributes into a single value that does not depend on the order the file
+// system listed them. Files with no attributes still have a digest, so they can be distinguished from Entries that
+// did not record attributes.
+func xattrDigest(xattrs map[string][]byte) string {
+	names := make([]string, 0, len(xattrs))
+
+	for name := range xattrs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	h := sha256.New()
+	length := make([]byte, 8)
+
+	// prefix each name and value with its length so different attributes cannot produce the same input
+	for _, name := range names {
+		binary.BigEndian.PutUint64(length, uint64(len(name)))
+		h.Write(length)
+		h.Write([]byte(name))
+
+		binary.BigEndian.PutUint64(length, uint64(len(xattrs[name])))
+		h.Write(length)
+		h.Write(xattrs[name])
+	}
+
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// joinXattrNames returns the sorted attribute names as a single value. Names can contain any character except NUL, so
+// each one is escaped before joining them with '/'.
+func joinXattrNames(xattrs map[string][]byte) string {
+	names := make([]string, 0, len(xattrs))
+
+	for name := range xattrs {
+		names = append(names, url.PathEscape(name))
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, "/")
+}
+
+// splitXattrNames is the inverse of joinXattrNames.
+func splitXattrNames(joined string) ([]string, error) {
+	if joined == "" {
+		return nil, nil
+	}
+
+	names := strings.Split(joined, "/")
+
+	for i, name := range names {
+		unescaped, err := url.PathUnescape(name)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid extended attribute name '%s': %v", name, err)
+		}
+
+		names[i] = unescaped
+	}
+
+	return names, nil
+}
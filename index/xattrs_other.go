@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package index
+
+import "os"
+
+// readXattrs always returns false since extended attributes are not supported on this platform.
+func readXattrs(path string, info os.FileInfo) (map[string][]byte, bool, error) {
+	return nil, false, nil
+}
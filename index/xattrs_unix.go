@@ -0,0 +1,85 @@
+//go:build linux || darwin || freebsd || netbsd
+// +build linux darwin freebsd netbsd
+
+package index
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of the given file. Returns false if the FileInfo does not come from the
+// operating system. File systems that do not support extended attributes are treated as having none. Symbolic links
+// that are being followed use the target's attributes.
+func readXattrs(path string, info os.FileInfo) (map[string][]byte, bool, error) {
+	if _, ok := info.Sys().(*syscall.Stat_t); !ok {
+		return nil, false, nil
+	}
+
+	list, get := unix.Listxattr, unix.Getxattr
+
+	if (info.Mode() & os.ModeSymlink) != 0 {
+		list, get = unix.Llistxattr, unix.Lgetxattr
+	}
+
+	names, err := readXattr(func(dest []byte) (int, error) { return list(path, dest) })
+
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return map[string][]byte{}, true, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	xattrs := make(map[string][]byte)
+
+	for _, name := range bytes.Split(names, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		value, err := readXattr(func(dest []byte) (int, error) { return get(path, string(name), dest) })
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		xattrs[string(name)] = value
+	}
+
+	return xattrs, true, nil
+}
+
+// readXattr calls the given function with a buffer large enough to hold the result. The first call with a nil buffer
+// returns the required size; retry if the attribute grew in between.
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return []byte{}, nil
+		}
+
+		dest := make([]byte, size)
+		size, err = read(dest)
+
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return dest[:size], nil
+	}
+}
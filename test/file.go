@@ -5,6 +5,8 @@ package test
 
 import (
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -24,6 +26,31 @@ func SetupTestFs(t *testing.T) {
 	})
 }
 
+// SetupOsFs switches to the real filesystem for tests that need features the in-memory filesystem does not support,
+// like symbolic links. Returns an empty temporary directory that is removed after the test.
+func SetupOsFs(t *testing.T) string {
+	oldFs := file.SetFs(afero.NewOsFs())
+
+	t.Cleanup(func() {
+		file.SetFs(oldFs)
+	})
+
+	return filepath.ToSlash(t.TempDir())
+}
+
+// MakeSymlink creates a symbolic link, skipping the test if links are not supported.
+func MakeSymlink(t *testing.T, target string, link string) {
+	linker, ok := file.GetFs().(afero.Linker)
+
+	if !ok {
+		t.Skip("file system does not support symbolic links")
+	}
+
+	if err := linker.SymlinkIfPossible(target, link); err != nil {
+		t.Skipf("cannot make link '%s': %v", link, err)
+	}
+}
+
 // MakeDir creates the given directory
 func MakeDir(t *testing.T, dir string) {
 	err := file.GetFs().MkdirAll(dir, 0755)
@@ -57,3 +84,70 @@ func MakeFile(t *testing.T, path string, data string, perm os.FileMode) os.FileI
 
 	return info
 }
+
+// ErrorFs wraps a file system and returns an error when opening any of the given paths.
+type ErrorFs struct {
+	afero.Fs
+	errors map[string]error
+}
+
+// SetupErrorFs wraps the current test file system so that opening any of the given paths returns its error.
+func SetupErrorFs(t *testing.T, errors map[string]error) {
+	oldFs := file.SetFs(ErrorFs{file.GetFs(), errors})
+
+	t.Cleanup(func() {
+		file.SetFs(oldFs)
+	})
+}
+
+func (e ErrorFs) Open(name string) (afero.File, error) {
+	if err, exists := e.errors[filepath.ToSlash(name)]; exists {
+		return nil, err
+	}
+
+	return e.Fs.Open(name)
+}
+
+// ChangingFs wraps a file system and appends to the given paths each time they are opened, simulating files that are
+// being written while they are read.
+type ChangingFs struct {
+	afero.Fs
+	mu      *sync.Mutex
+	changes map[string]int // remaining number of times to change each path
+}
+
+// SetupChangingFs wraps the current test file system so that opening any of the given paths appends a byte to the
+// file before it is read, up to the given number of times.
+func SetupChangingFs(t *testing.T, changes map[string]int) {
+	oldFs := file.SetFs(ChangingFs{file.GetFs(), &sync.Mutex{}, changes})
+
+	t.Cleanup(func() {
+		file.SetFs(oldFs)
+	})
+}
+
+func (c ChangingFs) Open(name string) (afero.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name = filepath.ToSlash(name)
+
+	if c.changes[name] > 0 {
+		c.changes[name]--
+
+		f, err := c.Fs.OpenFile(name, os.O_APPEND|os.O_WRONLY, 0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = f.Write([]byte("x"))
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.Fs.Open(name)
+}
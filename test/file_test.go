@@ -1,7 +1,10 @@
 package test
 
 import (
+	"io/fs"
 	"testing"
+
+	"github.com/hpresnall/yabrc/file"
 )
 
 func Test(t *testing.T) {
@@ -12,3 +15,54 @@ func Test(t *testing.T) {
 
 	RemoveDir(t, "dir")
 }
+
+func TestErrorFs(t *testing.T) {
+	SetupTestFs(t)
+
+	MakeFile(t, "test", "foo", 0644)
+	MakeFile(t, "error", "foo", 0644)
+
+	SetupErrorFs(t, map[string]error{"error": fs.ErrPermission})
+
+	if _, err := file.GetFs().Open("test"); err != nil {
+		t.Error("should be able to open file", err)
+	}
+
+	if _, err := file.GetFs().Open("error"); err != fs.ErrPermission {
+		t.Error("should not be able to open file", err)
+	}
+}
+
+func TestOsFs(t *testing.T) {
+	dir := SetupOsFs(t)
+
+	MakeFile(t, dir+"/test", "foo", 0644)
+	MakeSymlink(t, "test", dir+"/link")
+
+	if _, err := file.GetFs().Open(dir + "/link"); err != nil {
+		t.Error("should be able to open link", err)
+	}
+}
+
+func TestChangingFs(t *testing.T) {
+	SetupTestFs(t)
+
+	MakeFile(t, "test", "foo", 0644)
+
+	SetupChangingFs(t, map[string]int{"test": 1})
+
+	for _, expected := range []int64{4, 4} {
+		f, err := file.GetFs().Open("test")
+
+		if err != nil {
+			t.Fatal("should be able to open file", err)
+		}
+
+		info, _ := f.Stat()
+		f.Close()
+
+		if info.Size() != expected {
+			t.Error("file should be changed once", info.Size())
+		}
+	}
+}
@@ -1,32 +1,139 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"hash"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/spf13/afero"
 	log "github.com/spf13/jwalterweatherman"
+	"golang.org/x/text/unicode/norm"
 
 	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
 	"github.com/hpresnall/yabrc/index"
 )
 
+// ErrInterrupted is returned by BuildIndexWithOptions when its context is cancelled before the walk completes.
+var ErrInterrupted = errors.New("index build interrupted")
+
+// DefaultCheckpointInterval is used when checkpoints are enabled without a CheckpointInterval.
+const DefaultCheckpointInterval = 5 * time.Minute
+
+// BuildOptions controls how BuildIndexWithOptions builds an Index.
+type BuildOptions struct {
+	// Existing is an Index whose Entries are reused for files that have not changed; see BuildIndex.
+	Existing *index.Index
+	// Context stops the build when cancelled. A final checkpoint is stored, if enabled. Defaults to
+	// context.Background().
+	Context context.Context
+	// CheckpointExt is the extension used to periodically store the partially built Index. Empty disables checkpoints.
+	// The checkpoint is removed once the build completes.
+	CheckpointExt string
+	// CheckpointInterval is the minimum time between checkpoints. Defaults to DefaultCheckpointInterval.
+	CheckpointInterval time.Duration
+	// Resume reuses the Entries from an existing checkpoint for files that have not changed.
+	// If the checkpoint cannot be loaded, all files are hashed.
+	Resume bool
+	// Progress receives updates every ProgressInterval. Nil disables progress reporting.
+	Progress         ProgressReporter
+	ProgressInterval time.Duration
+	// ExpectedBytes is the total size of all the files expected in the Index, e.g. from a previous Index.
+	// Used to estimate the time remaining. Zero if unknown.
+	ExpectedBytes int64
+	// ScrubFraction and ScrubBytes rehash a slice of the unchanged files in the Existing Index, even though their
+	// Entries could be reused. Files verified longest ago are rehashed first. The larger of the two limits is used.
+	// ScrubFraction is a fraction of the total bytes in the Existing Index, from 0 to 1.
+	ScrubFraction float64
+	ScrubBytes    int64
+}
+
+// a file found by the walk that needs to be hashed
+type hashJob struct {
+	seq       int // position in the walk
+	path      string
+	info      os.FileInfo
+	link      *linkedEntry // nil unless the file has multiple hard links
+	firstLink bool         // the first link found is hashed; the others reuse its Entry
+}
+
+// linkedEntry shares the Entry for the first path found for a hard linked file with the jobs for its other paths.
+// done is closed once entry and err are set.
+type linkedEntry struct {
+	done  chan struct{}
+	entry index.Entry
+	err   error
+}
+
+// an Entry ready to be added to the index, either newly hashed or reused from an existing index
+type hashResult struct {
+	seq        int
+	path       string
+	entry      index.Entry
+	err        error
+	reused     bool // not hashed
+	unreadable bool // entry records the error
+	unhashed   bool // directory or symbolic link; nothing to hash
+	unstable   bool // entry records that the file kept changing while being hashed
+	removed    bool // file was removed after the walk found it; nothing to add
+}
+
 // BuildIndex creates an Index by walking the file system from Config.Root().
 // If an existing Index is passed in, only new & updated files will be scanned. Other files will use
 // the existing Index's Entries.
+// Files are hashed in parallel by Config.Workers() goroutines while the walk continues.
 func BuildIndex(config *config.Config, existingIdx *index.Index) (*index.Index, error) {
-	idx, err := index.New(config)
+	return BuildIndexWithOptions(config, BuildOptions{Existing: existingIdx})
+}
+
+// BuildIndexWithOptions creates an Index like BuildIndex, with support for checkpoints and cancellation.
+// If the build is interrupted, the partial Index is returned along with ErrInterrupted.
+func BuildIndexWithOptions(cfg *config.Config, options BuildOptions) (*index.Index, error) {
+	idx, err := index.New(cfg)
 
 	if err != nil {
 		return idx, err
 	}
 
-	log.INFO.Printf("building index for '%s'\n", idx.Config().Root())
+	workers := idx.Config().Workers()
+	existingIdx := options.Existing
+	ctx := options.Context
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// storing the checkpoint after every file would take time proportional to the square of the number of files
+	if options.CheckpointInterval <= 0 {
+		options.CheckpointInterval = DefaultCheckpointInterval
+	}
+
+	// existing Entries can only be reused if they were hashed with the same algorithm
+	if (existingIdx != nil) && (existingIdx.Hasher() != idx.Hasher()) {
+		log.WARN.Printf("existing index uses '%s', not '%s'; rehashing all files\n", existingIdx.Hasher(), idx.Hasher())
+		existingIdx = nil
+	}
+
+	scrub := selectScrub(existingIdx, options.ScrubFraction, options.ScrubBytes)
+
+	if len(scrub) > 0 {
+		log.INFO.Printf("scrubbing %d unchanged files\n", len(scrub))
+	}
+
+	var checkpointIdx *index.Index
+
+	if options.Resume && (options.CheckpointExt != "") {
+		checkpointIdx = loadCheckpoint(idx, options.CheckpointExt)
+	}
+
+	log.INFO.Printf("building index for '%s' with %d workers\n", idx.Config().Root(), workers)
 
 	start := time.Now()
 
@@ -34,32 +141,242 @@ func BuildIndex(config *config.Config, existingIdx *index.Index) (*index.Index,
 	zeroCount := 0
 	nonCount := 0
 	metadataCount := 0
+	ignoredCount := 0
+	filteredCount := 0
+	symlinkCount := 0
+	linkedCount := 0
 	hashedCount := 0
 	hashedBytes := int64(0)
 	existingCount := 0
-	errCount := 0
+	resumedCount := 0
+	scrubCount := 0
+	walkErrCount := 0
+	unreadableCount := 0
+	unstableCount := 0
+	addErrCount := 0
 	skippedBytes := int64(0)
 
-	err = afero.Walk(file.GetFs(), idx.Config().Root(), func(path string, info os.FileInfo, err error) error {
+	// walk => jobs => workers => results => collector
+	// the walk sends reused Entries directly to results
+	// only the collector modifies the index, so no locking is needed
+	jobs := make(chan hashJob, workers*2)
+	results := make(chan hashResult, workers*2)
+
+	var wg sync.WaitGroup
+
+	// limits are shared by all workers
+	readLimiter := newLimiter(float64(idx.Config().MaxReadRate()))
+	fileLimiter := newLimiter(float64(idx.Config().MaxFileRate()))
+
+	if readLimiter != nil {
+		log.INFO.Printf("limiting reads to %s/sec\n", humanize.Bytes(uint64(idx.Config().MaxReadRate())))
+	}
+
+	if fileLimiter != nil {
+		log.INFO.Printf("limiting hashing to %d files/sec\n", idx.Config().MaxFileRate())
+	}
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			hashFiles(ctx, idx, jobs, results, readLimiter, fileLimiter)
+		}()
+	}
+
+	collected := make(chan struct{})
+
+	// workers finish out of order; position is the last file in walk order where all previous files are complete
+	position := ""
+	completed := make(map[int]string)
+	nextSeq := 0
+	lastCheckpoint := time.Now()
+
+	// only modified by the collector
+	progress := Progress{ExpectedBytes: options.ExpectedBytes}
+	var ticks <-chan time.Time
+
+	if options.Progress != nil {
+		ticker := time.NewTicker(max(options.ProgressInterval, time.Millisecond))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	go func() {
+		for {
+			var r hashResult
+			var open bool
+
+			select {
+			case r, open = <-results:
+			case <-ticks:
+				progress.Elapsed = time.Since(start)
+				options.Progress.Progress(progress)
+				continue
+			}
+
+			if !open {
+				break
+			}
+
+			if r.reused {
+				progress.SkippedFiles++
+				progress.SkippedBytes += r.entry.Size()
+			} else if (r.err == nil) && !r.unreadable && !r.unhashed && !r.unstable && !r.removed {
+				progress.Files++
+				progress.Bytes += r.entry.Size()
+			}
+
+			progress.CurrentDir = filepath.ToSlash(filepath.Dir(r.path))
+
+			err := r.err
+
+			if (err == nil) && !r.removed {
+				err = idx.AddEntry(r.entry)
+			}
+
+			if err != nil {
+				addErrCount++
+				log.ERROR.Printf("cannot add '%s' to database: %v\n", r.path, err)
+			} else if r.unreadable {
+				unreadableCount++
+			} else if r.unstable {
+				unstableCount++
+			}
+
+			completed[r.seq] = r.path
+
+			for path, exists := completed[nextSeq]; exists; path, exists = completed[nextSeq] {
+				position = path
+				delete(completed, nextSeq)
+				nextSeq++
+			}
+
+			// only the collector modifies the index, so it is safe to store here
+			if (options.CheckpointExt != "") && (time.Since(lastCheckpoint) >= options.CheckpointInterval) {
+				storeCheckpoint(idx, options.CheckpointExt, position)
+				lastCheckpoint = time.Now()
+			}
+		}
+
+		close(collected)
+	}()
+
+	seq := 0
+	ignores := ignoreRules{}
+
+	// only modified by the walk
+	links := make(map[index.LinkID]*linkedEntry)
+	linkGroups := make(map[index.LinkID][]string)
+
+	// declared separately so followed symbolic links to directories can be walked recursively
+	var walkFn filepath.WalkFunc
+
+	walkFn = func(path string, info os.FileInfo, err error) error {
+		// stop walking; the returned error ends the walk
+		if ctx.Err() != nil {
+			return ErrInterrupted
+		}
+
 		if err != nil {
-			errCount++
 			log.WARN.Println("error reading file:", err.Error())
+
+			// an unreadable root means nothing can be indexed
+			if path == idx.Config().Root() {
+				walkErrCount++
+				return nil
+			}
+
+			// record the error so the file is not reported as removed
+			entry, err := idx.BuildErrorEntry(path, info, err)
+			results <- hashResult{seq: seq, path: relativePath(idx, path), entry: entry, err: err, unreadable: true}
+			seq++
+
+			// do not walk into unreadable directories
+			if (info != nil) && info.IsDir() {
+				return filepath.SkipDir
+			}
+
 			return nil
 		}
 
 		if info.IsDir() {
-			if config.IgnoreDir(path) {
+			if cfg.IgnoreDir(path) || ignores.ignored(cfg.Root(), path, true) {
 				log.DEBUG.Printf("skipping dir '%s'", path)
 				return filepath.SkipDir
 			}
 			log.DEBUG.Printf("indexing dir '%s'", path)
 
+			ignores.load(path)
+
 			dirCount++
+
+			// the root is implied by every other Entry
+			if cfg.RecordEmpty() && (path != cfg.Root()) {
+				entry, err := idx.BuildDirEntry(path, info)
+				results <- hashResult{seq: seq, path: relativePath(idx, path), entry: entry, err: err, unhashed: true}
+				seq++
+			}
+
 			return nil
-		} else if strings.HasSuffix(path, "desktop.ini") || strings.HasSuffix(path, ".DS_Store") {
+		} else if cfg.IsMetadata(path) {
 			// do not index file manager metadata since these can change frequently
 			metadataCount++
 			return nil
+		} else if cfg.IgnoreFile(path) || ignores.ignored(cfg.Root(), path, false) {
+			log.DEBUG.Printf("skipping file '%s'", path)
+			ignoredCount++
+			return nil
+		}
+
+		if (info.Mode() & os.ModeSymlink) != 0 {
+			switch cfg.Symlinks() {
+			case config.SymlinksRecord:
+				var entry index.Entry
+				unreadable := false
+				target, err := readLink(path)
+
+				if err == nil {
+					entry, err = idx.BuildSymlinkEntry(path, info, target)
+				} else {
+					log.WARN.Printf("cannot read link '%s': %v\n", path, err)
+					entry, err = idx.BuildErrorEntry(path, info, err)
+					unreadable = true
+				}
+
+				results <- hashResult{seq: seq, path: relativePath(idx, path), entry: entry, err: err, unreadable: unreadable, unhashed: true}
+				seq++
+				return nil
+			case config.SymlinksFollow:
+				target, err := file.GetFs().Stat(path)
+
+				if err != nil {
+					log.WARN.Printf("skipping broken link '%s': %v\n", path, err)
+					symlinkCount++
+					return nil
+				}
+
+				if target.IsDir() {
+					if linksToParent(cfg.Root(), path, target) {
+						log.WARN.Printf("skipping link '%s' to a parent directory\n", path)
+						symlinkCount++
+						return nil
+					}
+
+					// the trailing separator makes Walk's Lstat follow the link
+					return afero.Walk(file.GetFs(), path+string(filepath.Separator), func(path string, info os.FileInfo, err error) error {
+						return walkFn(filepath.Clean(path), info, err)
+					})
+				}
+
+				// hash the target as if it were a file at the link's path
+				info = target
+			default:
+				log.DEBUG.Printf("skipping link '%s'", path)
+				symlinkCount++
+				return nil
+			}
 		}
 
 		if (info.Mode() & os.ModeType) != 0 {
@@ -68,60 +385,133 @@ func BuildIndex(config *config.Config, existingIdx *index.Index) (*index.Index,
 			return nil
 		}
 
-		if info.Size() <= 0 {
+		if (info.Size() <= 0) && !cfg.RecordEmpty() {
 			zeroCount++
 			return nil
 		}
 
+		if cfg.FilterFile(info.Size(), info.ModTime(), start) {
+			if log.GetLogThreshold() == log.LevelTrace {
+				log.TRACE.Printf("filtering '%s' (%d bytes, modified %v)", path, info.Size(), info.ModTime())
+			}
+			filteredCount++
+			return nil
+		}
+
+		linkID, linked := index.HardLinkID(info)
+
+		// record all links, even unchanged ones, since the groups are rebuilt every time
+		if linked {
+			linkGroups[linkID] = append(linkGroups[linkID], norm.NFC.String(relativePath(idx, path)))
+		}
+
+		if entry, exists := unchangedEntry(checkpointIdx, path, info, cfg.CheckCtime()); exists {
+			if log.GetLogThreshold() == log.LevelTrace {
+				log.TRACE.Printf("resuming '%s'", path)
+			}
+			resumedCount++
+			skippedBytes += info.Size()
+			results <- hashResult{seq: seq, path: relativePath(idx, path), entry: idx.UpdateMetadata(entry, path, info), reused: true}
+			seq++
+			return nil
+		}
+
 		if existingIdx != nil {
-			path := strings.Replace(path, "\\", "/", -1)
-			entry, exists := existingIdx.Get(path)
+			entry, exists := unchangedEntry(existingIdx, path, info, cfg.CheckCtime())
 
-			// Entry.LastMod() stored as Unix time
-			infoTime := info.ModTime().Truncate(time.Second)
+			if exists {
+				if _, scrubbed := scrub[entry.Path()]; scrubbed {
+					scrubCount++
+					exists = false
+				}
+			}
 
 			// only add existing entry if the file was created afterwards or the sizes has changed
-			if exists &&
-				(entry.Size() == info.Size()) &&
-				(infoTime.Before(entry.LastMod()) || infoTime.Equal(entry.LastMod())) {
+			if exists {
 				if log.GetLogThreshold() == log.LevelTrace {
 					log.TRACE.Printf("skipping '%s': '%v' vs '%v' & '%d' vs '%d'", path, info.ModTime(), entry.LastMod(), info.Size(), entry.Size())
 				}
 				existingCount++
 				skippedBytes += info.Size()
-				err = idx.AddEntry(entry)
-			} else {
-				if log.GetLogThreshold() <= log.LevelDebug {
-					log.DEBUG.Printf("rescanning '%s': '%v' vs '%v' & '%d' vs '%d'", path, info.ModTime(), entry.LastMod(), info.Size(), entry.Size())
-				}
-				hashedCount++
-				hashedBytes += info.Size()
-				err = idx.Add(path, info)
+				results <- hashResult{seq: seq, path: relativePath(idx, path), entry: idx.UpdateMetadata(entry, path, info), reused: true}
+				seq++
+				return nil
 			}
-		} else {
+
 			if log.GetLogThreshold() <= log.LevelDebug {
-				log.DEBUG.Printf("adding '%s': '%v' & '%d'", path, info.ModTime(), info.Size())
+				log.DEBUG.Printf("rescanning '%s': '%v' vs '%v' & '%d' vs '%d'", path, info.ModTime(), entry.LastMod(), info.Size(), entry.Size())
+			}
+		} else if log.GetLogThreshold() <= log.LevelDebug {
+			log.DEBUG.Printf("adding '%s': '%v' & '%d'", path, info.ModTime(), info.Size())
+		}
+
+		job := hashJob{seq: seq, path: path, info: info}
+		seq++
+
+		if linked {
+			job.link = links[linkID]
+			job.firstLink = job.link == nil
+
+			if job.firstLink {
+				job.link = &linkedEntry{done: make(chan struct{})}
+				links[linkID] = job.link
 			}
+		}
+
+		if (job.link != nil) && !job.firstLink {
+			linkedCount++
+			skippedBytes += info.Size()
+		} else {
 			hashedCount++
 			hashedBytes += info.Size()
-			err = idx.Add(path, info)
 		}
 
-		if err != nil {
-			errCount++
-			log.ERROR.Printf("cannot add '%s' to database: %v\n", path, err)
-		}
+		jobs <- job
 
 		return nil
-	})
+	}
+
+	err = afero.Walk(file.GetFs(), idx.Config().Root(), walkFn)
+
+	// wait for all hashing to complete before reading the index
+	close(jobs)
+	wg.Wait()
+	close(results)
+	<-collected
+
+	groups := make([][]string, 0, len(linkGroups))
 
-	// index is truly empty, not just empty because all the files could not be read
-	if (idx.Size() == 0) && (errCount > 0) {
+	for _, group := range linkGroups {
+		groups = append(groups, group)
+	}
+
+	idx.SetHardLinks(groups)
+
+	if options.Progress != nil {
+		progress.Elapsed = time.Since(start)
+		options.Progress.Done(progress)
+	}
+
+	errCount := walkErrCount + addErrCount + unreadableCount
+	interrupted := errors.Is(err, ErrInterrupted) || (ctx.Err() != nil)
+
+	if interrupted {
+		err = ErrInterrupted
+
+		if options.CheckpointExt != "" {
+			storeCheckpoint(idx, options.CheckpointExt, position)
+		}
+	} else if (idx.Size() == unreadableCount) && (errCount > 0) {
+		// index is truly empty, not just empty because all the files could not be read
 		err = errors.New("no files successfully read from '" + idx.Config().Root() + "'")
 	}
 
+	if !interrupted && (options.CheckpointExt != "") {
+		removeCheckpoint(idx, options.CheckpointExt)
+	}
+
 	d := time.Since(start)
-	skippedCount := zeroCount + nonCount + existingCount + metadataCount
+	skippedCount := zeroCount + nonCount + existingCount + resumedCount + metadataCount + ignoredCount + filteredCount + symlinkCount + linkedCount
 
 	dRounded := d.Round(time.Second)
 
@@ -134,9 +524,252 @@ func BuildIndex(config *config.Config, existingIdx *index.Index) (*index.Index,
 	log.INFO.Printf("%d directories, %d files hashed, %d errors; %.f files/sec; %s/sec\n", dirCount, hashedCount, errCount, float64(hashedCount)/d.Seconds(), humanize.Bytes(uint64(float64(hashedBytes)/d.Seconds())))
 
 	if skippedCount > 0 {
-		log.INFO.Printf("%d skipped (%s); %d not changed, %d zero byte, %d dir metadata, %d non-file", skippedCount, humanize.Bytes(uint64(skippedBytes)), existingCount, zeroCount, metadataCount, nonCount)
+		log.INFO.Printf("%d skipped (%s); %d not changed, %d zero byte, %d dir metadata, %d ignored, %d filtered by size or age, %d symlink, %d hard link, %d non-file", skippedCount, humanize.Bytes(uint64(skippedBytes)), existingCount, zeroCount, metadataCount, ignoredCount, filteredCount, symlinkCount, linkedCount, nonCount)
+	}
+
+	if scrubCount > 0 {
+		log.INFO.Printf("%d unchanged files rehashed by scrub", scrubCount)
+	}
+
+	if resumedCount > 0 {
+		log.INFO.Printf("%d files resumed from checkpoint", resumedCount)
+	}
+
+	if unstableCount > 0 {
+		log.WARN.Printf("%d files changed while being hashed; recorded as unstable", unstableCount)
 	}
 
 	// return err from filepath.Walk(), if any
 	return idx, err
 }
+
+// hashFiles builds an Entry for each job until the jobs channel is closed.
+// Each call uses its own hash, so multiple calls can run concurrently.
+// Once the context is cancelled, remaining jobs are skipped; they will not be included in the checkpoint's position.
+// Either limiter may be nil.
+func hashFiles(ctx context.Context, idx *index.Index, jobs <-chan hashJob, results chan<- hashResult, readLimiter *limiter, fileLimiter *limiter) {
+	h := idx.Hasher().New()
+
+	if readLimiter != nil {
+		h = throttledHash{h, readLimiter, ctx}
+	}
+
+	h = cancellableHash{h, ctx}
+
+	for job := range jobs {
+		if ctx.Err() != nil {
+			if job.firstLink {
+				job.link.err = ctx.Err()
+				close(job.link.done)
+			}
+			continue
+		}
+
+		if (job.link != nil) && !job.firstLink {
+			// the first link was queued earlier, so it is already being hashed by another worker
+			<-job.link.done
+
+			if job.link.err == nil {
+				entry, err := idx.BuildHardLinkEntry(job.path, job.link.entry)
+				results <- hashResult{seq: job.seq, path: relativePath(idx, job.path), entry: entry, err: err, reused: true}
+				continue
+			}
+
+			// first link could not be read; try this path instead
+		}
+
+		// a cancelled wait stops hashing at the first write; the partial hash is dropped below
+		fileLimiter.wait(ctx, 1)
+
+		entry, err := hashFile(idx, job.path, job.info, h)
+		unreadable := false
+		unstable := false
+		removed := errors.Is(err, fs.ErrNotExist)
+
+		// drop partially hashed files; they are not included in the checkpoint's position
+		if ctx.Err() != nil {
+			if job.firstLink {
+				job.link.err = ctx.Err()
+				close(job.link.done)
+			}
+			continue
+		}
+
+		if errors.Is(err, index.ErrUnstable) {
+			log.WARN.Printf("'%s' changed while being hashed %d times; recording as unstable\n", job.path, idx.Config().HashRetries()+1)
+			entry, err = idx.BuildErrorEntry(job.path, job.info, err)
+			unstable = true
+		} else if removed {
+			// files removed since the walk found them are not unreadable, just gone
+			log.DEBUG.Printf("'%s' was removed before it could be hashed; skipping\n", job.path)
+		} else if err != nil {
+			log.WARN.Printf("cannot read '%s': %v\n", job.path, err)
+			entry, err = idx.BuildErrorEntry(job.path, job.info, err)
+			unreadable = true
+		}
+
+		if job.firstLink {
+			job.link.entry = entry
+			job.link.err = err
+
+			if unreadable || unstable {
+				job.link.err = errors.New(entry.Error())
+			}
+
+			close(job.link.done)
+		}
+
+		if removed {
+			err = nil
+		}
+
+		results <- hashResult{seq: job.seq, path: relativePath(idx, job.path), entry: entry, err: err, unreadable: unreadable, unstable: unstable, removed: removed}
+	}
+}
+
+// hashFile builds an Entry for the file, then checks that the file did not change while it was being read, since the
+// hash would not match the recorded size and modification time. Changed files are rehashed up to the Config's
+// HashRetries(); after that, index.ErrUnstable is returned.
+func hashFile(idx *index.Index, path string, info os.FileInfo, h hash.Hash) (index.Entry, error) {
+	for retries := 0; ; retries++ {
+		entry, err := idx.BuildEntry(path, info, h)
+
+		if err != nil {
+			return entry, err
+		}
+
+		// Stat, not Lstat, to match followed symbolic links
+		after, err := file.GetFs().Stat(path)
+
+		if err != nil {
+			return entry, err
+		}
+
+		if (after.Size() == info.Size()) && after.ModTime().Equal(info.ModTime()) {
+			return entry, nil
+		}
+
+		if retries >= idx.Config().HashRetries() {
+			return entry, index.ErrUnstable
+		}
+
+		log.DEBUG.Printf("'%s' changed while being hashed; rehashing\n", path)
+		info = after
+	}
+}
+
+// unchangedEntry returns the Entry from the given Index if the file has not changed since it was hashed.
+// If checkCtime is set, files with a different status change time or inode are also considered changed, since
+// tools like rsync and tar can replace a file's contents while preserving its modification time.
+func unchangedEntry(idx *index.Index, path string, info os.FileInfo, checkCtime bool) (index.Entry, bool) {
+	if idx == nil {
+		return index.Entry{}, false
+	}
+
+	entry, exists := idx.Get(strings.Replace(path, "\\", "/", -1))
+
+	// always try to read files that had errors; a directory or other type may have been replaced by a file
+	if !exists || entry.IsError() || !entry.IsFile() || (entry.Size() != info.Size()) {
+		return entry, false
+	}
+
+	if checkCtime && entry.StatusChanged(info) {
+		return entry, false
+	}
+
+	// Entry.LastMod() stored as Unix time
+	infoTime := info.ModTime().Truncate(time.Second)
+
+	// file must not be modified after the Entry was created
+	return entry, infoTime.Before(entry.LastMod()) || infoTime.Equal(entry.LastMod())
+}
+
+// readLink returns the contents of the given symbolic link, if the file system supports links.
+func readLink(path string) (string, error) {
+	reader, ok := file.GetFs().(afero.LinkReader)
+
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: path, Err: afero.ErrNoReadlink}
+	}
+
+	return reader.ReadlinkIfPossible(path)
+}
+
+// linksToParent returns true if the target of the given link is the root or one of the link's parent directories.
+// Following these links would never finish.
+func linksToParent(root string, link string, target os.FileInfo) bool {
+	// parent paths may include other followed links, so Stat resolves them to the actual directories
+	for dir := filepath.Dir(link); len(dir) >= len(root); dir = filepath.Dir(dir) {
+		if info, err := file.GetFs().Stat(dir); (err == nil) && os.SameFile(info, target) {
+			return true
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return false
+}
+
+// relativePath returns the path without the Index's root.
+func relativePath(idx *index.Index, path string) string {
+	return strings.TrimPrefix(strings.Replace(path, "\\", "/", -1), idx.Config().Root()+"/")
+}
+
+// loadCheckpoint loads the checkpoint for the given Index. Returns nil if it cannot be reused.
+func loadCheckpoint(idx *index.Index, ext string) *index.Index {
+	checkpoint, err := index.Load(idx.Config(), ext)
+
+	if err != nil {
+		log.WARN.Printf("cannot load checkpoint; hashing all files: %v\n", err)
+		return nil
+	}
+
+	if checkpoint.Hasher() != idx.Hasher() {
+		log.WARN.Printf("checkpoint uses '%s', not '%s'; hashing all files\n", checkpoint.Hasher(), idx.Hasher())
+		return nil
+	}
+
+	log.INFO.Printf("resuming from checkpoint after '%s'\n", checkpoint.Position())
+
+	return checkpoint
+}
+
+// storeCheckpoint stores the partial Index. Errors are logged but do not stop the build.
+// The checkpoint is written to a temporary file first so a failure never overwrites the previous checkpoint.
+func storeCheckpoint(idx *index.Index, ext string, position string) {
+	if idx.Size() == 0 {
+		return
+	}
+
+	idx.SetPosition(position)
+	defer idx.SetPosition("")
+
+	tmpExt := ext + "_tmp"
+
+	if err := idx.Store(tmpExt); err != nil {
+		log.WARN.Printf("cannot store checkpoint: %v\n", err)
+		return
+	}
+
+	if err := file.GetFs().Rename(idx.GetFile(tmpExt), idx.GetFile(ext)); err != nil {
+		log.WARN.Printf("cannot store checkpoint: %v\n", err)
+		return
+	}
+
+	log.DEBUG.Printf("stored checkpoint with %d files to '%s'", idx.Size(), idx.GetFile(ext))
+}
+
+// removeCheckpoint removes the checkpoint for a complete Index.
+func removeCheckpoint(idx *index.Index, ext string) {
+	checkpoint := idx.GetFile(ext)
+
+	if exists, _ := afero.Exists(file.GetFs(), checkpoint); !exists {
+		return
+	}
+
+	if err := file.GetFs().Remove(checkpoint); err != nil {
+		log.WARN.Printf("cannot remove checkpoint '%s': %v\n", checkpoint, err)
+	}
+}
@@ -1,13 +1,19 @@
 package util
 
 import (
+	"context"
+	"io/fs"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/spf13/afero"
 	log "github.com/spf13/jwalterweatherman"
 
 	"github.com/hpresnall/yabrc/config"
 	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/index"
 	"github.com/hpresnall/yabrc/test"
 )
 
@@ -101,3 +107,494 @@ func TestBuildIndex(t *testing.T) {
 		t.Error("entries should not have the same hashes")
 	}
 }
+
+func TestBuildIndexFileFilters(t *testing.T) {
+	config := *IndexForTest(t).Config()
+
+	if err := config.SetIgnoredFiles([]string{"_2$"}); err != nil {
+		t.Fatal("cannot set ignoredFiles", err)
+	}
+	if err := config.SetIncludeOnly([]string{"/test2/"}); err != nil {
+		t.Fatal("cannot set includeOnly", err)
+	}
+
+	idx, err := BuildIndex(&config, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	for _, path := range []string{"test2/test2_1", "test2/sub1/test2_sub1_1"} {
+		if _, exists := idx.Get(path); !exists {
+			t.Errorf("'%s' should be included", path)
+		}
+	}
+
+	if idx.Size() != 2 {
+		t.Error("Index should have 2 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+
+	// index all metadata except desktop.ini and AppleDouble files
+	config.SetIgnoredFiles(nil)
+	config.SetIncludeOnly(nil)
+	test.MakeFile(t, config.Root()+"/test1/Thumbs.db", "Thumbs.db", 0644)
+	test.MakeFile(t, config.Root()+"/test1/._test1_1", "._test1_1", 0644)
+
+	if err = config.SetMetadataFiles([]string{"desktop.ini", "._*"}); err != nil {
+		t.Fatal("cannot set metadataFiles", err)
+	}
+
+	idx, err = BuildIndex(&config, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	for _, path := range []string{".DS_Store", "test1/Thumbs.db"} {
+		if _, exists := idx.Get(path); !exists {
+			t.Errorf("'%s' should be indexed", path)
+		}
+	}
+
+	for _, path := range []string{"desktop.ini", "test1/._test1_1"} {
+		if _, exists := idx.Get(path); exists {
+			t.Errorf("'%s' should not be indexed", path)
+		}
+	}
+}
+
+func TestBuildIndexSizeAndAgeFilters(t *testing.T) {
+	config := *IndexForTest(t).Config()
+	root := config.Root()
+
+	// 7 bytes, modified last week
+	old := time.Now().Add(-7 * 24 * time.Hour)
+	file.GetFs().Chtimes(root+"/test1/test1_1", old, old)
+
+	if err := config.SetMinSize("6"); err != nil {
+		t.Fatal("cannot set minSize", err)
+	}
+	if err := config.SetMaxSize("10"); err != nil {
+		t.Fatal("cannot set maxSize", err)
+	}
+
+	idx, err := BuildIndex(&config, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// test3 is 5 bytes, test2_sub1_1 is 12
+	for _, path := range []string{"test1/test1_1", "test2/test2_1", "test2/sub1/test2_sub1_2"} {
+		if _, exists := idx.Get(path); !exists {
+			t.Errorf("'%s' should be indexed", path)
+		}
+	}
+
+	if idx.Size() != 3 {
+		t.Error("Index should have 3 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+
+	if err = config.SetOlderThan("1d"); err != nil {
+		t.Fatal("cannot set olderThan", err)
+	}
+
+	idx, err = BuildIndex(&config, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if _, exists := idx.Get("test1/test1_1"); !exists || (idx.Size() != 1) {
+		t.Error("Index should only contain the old file", idx.StringWithEntries())
+	}
+}
+
+func TestBuildIndexRecordEmpty(t *testing.T) {
+	config := *IndexForTest(t).Config()
+	config.SetRecordEmpty(true)
+	root := config.Root()
+
+	idx, err := BuildIndex(&config, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	for _, path := range []string{"test1", "test2", "test3", "test2/sub1", "test2/sub2"} {
+		if e, _ := idx.Get(path); !e.IsDir() {
+			t.Errorf("'%s' should be a directory Entry", path)
+		}
+	}
+
+	if e, exists := idx.Get("test2/sub1/test2_3"); !exists || (e.Size() != 0) {
+		t.Error("zero byte file should be indexed")
+	}
+
+	// 5 files, 1 zero byte file, 5 directories; root and ignored directories are not recorded
+	if idx.Size() != 11 {
+		t.Error("Index should have 11 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+
+	// losing the empty directory and file is a difference
+	test.RemoveDir(t, root+"/test2/sub2")
+	file.GetFs().Remove(root + "/test2/sub1/test2_3")
+
+	// replacing a file with a directory is a modification
+	file.GetFs().Remove(root + "/test3/test3")
+	test.MakeDir(t, root+"/test3/test3")
+
+	newIdx, err := BuildIndex(&config, idx)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	result, err := CompareIndexes(newIdx, idx, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	if (result.Counts[Removed] != 2) || (result.Counts[Modified] != 1) || (result.Counts[Added] != 0) {
+		t.Error("should have 2 removed and 1 modified, not", result.Counts)
+	}
+}
+
+func TestBuildIndexWorkers(t *testing.T) {
+	serial := IndexForTest(t)
+	cfg := *serial.Config()
+
+	// add enough files to keep multiple workers busy
+	for i := range 50 {
+		test.MakeFile(t, cfg.Root()+"/many/"+strconv.Itoa(i), "data"+strconv.Itoa(i), 0644)
+	}
+
+	if err := cfg.SetWorkers(1); err != nil {
+		t.Fatal("should be able to set workers", err)
+	}
+
+	serial, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if err := cfg.SetWorkers(8); err != nil {
+		t.Fatal("should be able to set workers", err)
+	}
+
+	parallel, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if serial.Size() != parallel.Size() {
+		t.Fatal("Index size should be the same", serial.Size(), parallel.Size())
+	}
+
+	serial.ForEach(func(e1 index.Entry) {
+		e2, exists := parallel.Get(e1.Path())
+
+		if !exists {
+			t.Errorf("parallel Index should contain '%s'", e1.Path())
+			return
+		}
+
+		if (e1.Hash() != e2.Hash()) || (e1.Size() != e2.Size()) || !e1.LastMod().Equal(e2.LastMod()) {
+			t.Error("entries should be identical", e1, e2)
+		}
+	})
+}
+
+func TestBuildIndexDifferentHasher(t *testing.T) {
+	idx := IndexForTest(t)
+
+	cfg := *idx.Config()
+	cfg.SetHash("crc32c")
+
+	// existing index should be ignored since the hashes cannot be reused
+	newIdx, err := BuildIndex(&cfg, idx)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if newIdx.Hasher() != index.CRC32C {
+		t.Fatal("Index should use crc32c, not", newIdx.Hasher())
+	}
+
+	if idx.Size() != newIdx.Size() {
+		t.Fatal("Index size should be the same", idx.Size(), newIdx.Size())
+	}
+
+	newIdx.ForEach(func(e index.Entry) {
+		old, _ := idx.Get(e.Path())
+
+		if e.Hash() == old.Hash() {
+			t.Errorf("'%s' should have been rehashed", e.Path())
+		}
+	})
+}
+
+func TestBuildIndexInterrupted(t *testing.T) {
+	cfg := IndexForTest(t).Config()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	idx, err := BuildIndexWithOptions(cfg, BuildOptions{Context: ctx, CheckpointExt: "_partial"})
+
+	if err != ErrInterrupted {
+		t.Fatal("should be interrupted, not", err)
+	}
+
+	if idx.Size() != 0 {
+		t.Error("Index should be empty")
+	}
+
+	// nothing to checkpoint
+	if exists, _ := afero.Exists(file.GetFs(), idx.GetFile("_partial")); exists {
+		t.Error("empty checkpoint should not be stored")
+	}
+}
+
+// renameFs counts the renames used to store checkpoints
+type renameFs struct {
+	afero.Fs
+	renames *int
+}
+
+func (r renameFs) Rename(oldname string, newname string) error {
+	*r.renames++
+	return r.Fs.Rename(oldname, newname)
+}
+
+func TestBuildIndexCheckpointInterval(t *testing.T) {
+	cfg := IndexForTest(t).Config()
+
+	renames := 0
+	oldFs := file.SetFs(renameFs{file.GetFs(), &renames})
+	t.Cleanup(func() { file.SetFs(oldFs) })
+
+	// no interval uses the default, so a quick build stores no checkpoints
+	if _, err := BuildIndexWithOptions(cfg, BuildOptions{CheckpointExt: "_partial"}); err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if renames != 0 {
+		t.Error("should not store checkpoints before the default interval, but stored", renames)
+	}
+
+	if _, err := BuildIndexWithOptions(cfg, BuildOptions{CheckpointExt: "_partial", CheckpointInterval: time.Nanosecond}); err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if renames == 0 {
+		t.Error("should store checkpoints with a short interval")
+	}
+}
+
+func TestBuildIndexCheckpoint(t *testing.T) {
+	idx := IndexForTest(t)
+	cfg := idx.Config()
+
+	storeCheckpoint(idx, "_partial", "test3/test3")
+
+	if idx.Position() != "" {
+		t.Error("Index should not have a position after storing a checkpoint")
+	}
+
+	checkpoint, err := index.Load(cfg, "_partial")
+
+	if err != nil {
+		t.Fatal("should be able to load checkpoint", err)
+	}
+
+	if (checkpoint.Position() != "test3/test3") || (checkpoint.Size() != idx.Size()) {
+		t.Error("checkpoint should be identical to Index", checkpoint, checkpoint.Position())
+	}
+
+	// same size and time, so the checkpoint's hash should be reused
+	path := cfg.Root() + "/test3/test3"
+	entry, _ := idx.Get(path)
+	test.MakeFile(t, path, "DATA3", 0644)
+	file.GetFs().Chtimes(path, entry.LastMod(), entry.LastMod())
+
+	// checkpoint every file
+	resumed, err := BuildIndexWithOptions(cfg, BuildOptions{CheckpointExt: "_partial", CheckpointInterval: time.Nanosecond, Resume: true})
+
+	if err != nil {
+		t.Fatal("should be able to resume", err)
+	}
+
+	if resumed.Size() != idx.Size() {
+		t.Error("resumed Index should have the same size", resumed.Size(), idx.Size())
+	}
+
+	if e, _ := resumed.Get("test3/test3"); e.Hash() != entry.Hash() {
+		t.Error("resumed Index should reuse the checkpoint's hash", e, entry)
+	}
+
+	for _, ext := range []string{"_partial", "_partial_tmp"} {
+		if exists, _ := afero.Exists(file.GetFs(), idx.GetFile(ext)); exists {
+			t.Errorf("'%s' should be removed after the build completes", ext)
+		}
+	}
+}
+
+func TestBuildIndexResumeNoCheckpoint(t *testing.T) {
+	idx := IndexForTest(t)
+
+	resumed, err := BuildIndexWithOptions(idx.Config(), BuildOptions{CheckpointExt: "_partial", CheckpointInterval: time.Hour, Resume: true})
+
+	if err != nil {
+		t.Fatal("should be able to build without a checkpoint", err)
+	}
+
+	if resumed.Size() != idx.Size() {
+		t.Error("Index should have the same size", resumed.Size(), idx.Size())
+	}
+}
+
+func TestBuildIndexResumeDifferentHasher(t *testing.T) {
+	idx := IndexForTest(t)
+	storeCheckpoint(idx, "_partial", "")
+
+	cfg := *idx.Config()
+	cfg.SetHash("crc32c")
+
+	// checkpoint should be ignored since the hashes cannot be reused
+	resumed, err := BuildIndexWithOptions(&cfg, BuildOptions{CheckpointExt: "_partial", Resume: true})
+
+	if err != nil {
+		t.Fatal("should be able to build", err)
+	}
+
+	resumed.ForEach(func(e index.Entry) {
+		if len(e.Hash()) != 6 {
+			t.Error("all entries should be rehashed with crc32c", e)
+		}
+	})
+}
+
+func TestBuildIndexRemovedWhileHashing(t *testing.T) {
+	idx := IndexForTest(t)
+	root := idx.Config().Root()
+
+	// the walk finds the files, but they are gone before they are hashed
+	removed := map[string]error{}
+
+	idx.ForEach(func(e index.Entry) {
+		removed[root+"/"+e.Path()] = fs.ErrNotExist
+	})
+
+	test.SetupErrorFs(t, removed)
+
+	// removed files are not errors, so an empty index is not a failure
+	idx2, err := BuildIndex(idx.Config(), nil)
+
+	if err != nil {
+		t.Fatal("removed files should not be errors", err)
+	}
+
+	if idx2.Size() != 0 {
+		t.Error("removed files should not be in the Index", idx2.StringWithEntries())
+	}
+}
+
+func TestBuildIndexUnstable(t *testing.T) {
+	// in-memory FileInfos are updated as the file changes, so use the real file system
+	root := test.SetupOsFs(t)
+
+	test.MakeFile(t, root+"/stable", "data1", 0644)
+	test.MakeFile(t, root+"/unstable", "data2", 0644)
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	// stable settles after 2 changes; unstable keeps changing
+	test.SetupChangingFs(t, map[string]int{root + "/stable": 2, root + "/unstable": 10})
+
+	idx, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	stable, _ := idx.Get("stable")
+	info, _ := file.GetFs().Stat(root + "/stable")
+
+	if stable.IsError() || (stable.Size() != info.Size()) || !stable.LastMod().Equal(info.ModTime()) {
+		t.Error("Entry should match the file after it stopped changing", stable, info.Size())
+	}
+
+	if unstable, _ := idx.Get("unstable"); unstable.Error() != index.ErrorUnstable {
+		t.Error("Entry should be unstable", unstable)
+	}
+
+	cfg.SetHashRetries(0)
+	test.SetupChangingFs(t, map[string]int{root + "/stable": 1})
+
+	if idx, err = BuildIndex(&cfg, nil); err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if e, _ := idx.Get("stable"); e.Error() != index.ErrorUnstable {
+		t.Error("Entry should be unstable without retries", e)
+	}
+}
+
+func TestBuildIndexCheckCtime(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("status change times are only recorded on Unix")
+	}
+
+	root := test.SetupOsFs(t)
+	info := test.MakeFile(t, root+"/test", "data1", 0644)
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	idx, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// replace the file with the same size and modification time, like rsync -t
+	test.MakeFile(t, root+"/replacement", "data2", 0644)
+	file.GetFs().Chtimes(root+"/replacement", info.ModTime(), info.ModTime())
+
+	if err = file.GetFs().Rename(root+"/replacement", root+"/test"); err != nil {
+		t.Fatal("cannot replace file", err)
+	}
+
+	original, _ := idx.Get("test")
+
+	for _, checkCtime := range []bool{true, false} {
+		cfg.SetCheckCtime(checkCtime)
+		updated, err := BuildIndex(&cfg, idx)
+
+		if err != nil {
+			t.Fatal("should be able to update the Index", err)
+		}
+
+		e, _ := updated.Get("test")
+
+		if checkCtime && (e.Hash() == original.Hash()) {
+			t.Error("replaced file should be rehashed", e)
+		}
+
+		if !checkCtime && (e.Hash() != original.Hash()) {
+			t.Error("existing Entry should be reused without checking ctime", e)
+		}
+	}
+}
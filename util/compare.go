@@ -1,7 +1,12 @@
 package util
 
 import (
+	"errors"
+	"fmt"
+	gopath "path"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
@@ -10,91 +15,620 @@ import (
 	"github.com/hpresnall/yabrc/index"
 )
 
-// Compare examines the Entries in the given Indexes and returns true if they are all the same.
-// Supports custom output on missing entries or entries that have different hashes.
-func Compare(one *index.Index, two *index.Index, ignoreMissing bool) bool {
-	if one == two {
-		return true
+// Category classifies the difference between two Entries with the same path.
+type Category int
+
+const (
+	// Same files have the same hash and last modification time.
+	Same Category = iota
+	// Added files only exist in the first index.
+	Added
+	// Removed files only exist in the second index.
+	Removed
+	// Modified files have different hashes and a different size or last modification time, i.e. a normal edit.
+	Modified
+	// Corrupted files have different hashes but the same size and last modification time. This usually means bit rot.
+	Corrupted
+	// Touched files have the same hash but a different last modification time.
+	Touched
+	// Moved files were removed from one path and added at another with the same hash and size.
+	// Only reported after CompareResult.DetectMoves().
+	Moved
+	// Copied files are additional copies of a Moved file. Only reported after CompareResult.DetectMoves().
+	Copied
+	// Unreadable files could not be read when the first index was built. An I/O error on a previously good file is
+	// a strong sign of bit rot.
+	Unreadable
+	// Permissions files have the same contents but a different mode or owner. Only reported if both indexes recorded
+	// permissions.
+	Permissions
+	// Xattrs files have the same contents but different extended attributes. Only reported if both indexes recorded
+	// extended attributes.
+	Xattrs
+	// Links files have the same contents but are hard linked to different files. Only reported after
+	// CompareResult.DetectHardLinks().
+	Links
+	// Unstable files kept changing while the first index was being built, so they have no hash. This is expected for
+	// files that are still being written, so it is not a difference.
+	Unstable
+)
+
+// Categories lists all the Categories that represent a difference, in output order.
+var Categories = []Category{Added, Removed, Modified, Corrupted, Unreadable, Unstable, Touched, Permissions, Xattrs, Links, Moved, Copied}
+
+var categoryNames = []string{"same", "added", "removed", "modified", "corrupted", "touched", "moved", "copied", "unreadable", "permissions", "xattrs", "links", "unstable"}
+var categorySymbols = []string{"=", "+", "-", "~", "#", "^", "@", "&", "!", "%", "*", "|", "?"}
+
+func (c Category) String() string {
+	return categoryNames[c]
+}
+
+// Symbol returns the character used to output the Category.
+// Note that Modified files will use '<' or '>' instead of '~' if the file size changed.
+func (c Category) Symbol() string {
+	return categorySymbols[c]
+}
+
+// Classify returns the Category for the given Entries.
+// Either Entry may not exist, but not both.
+func Classify(e1 index.Entry, exists1 bool, e2 index.Entry, exists2 bool) Category {
+	if !exists1 {
+		return Removed
+	}
+	if e1.Error() == index.ErrorUnstable {
+		return Unstable
+	}
+	if e1.IsError() {
+		return Unreadable
+	}
+	if !exists2 {
+		return Added
+	}
+	if e2.IsError() {
+		// readable again, but there is no hash to compare
+		return Modified
+	}
+
+	category := classifyContents(e1, e2)
+
+	// content changes take precedence
+	if (category == Same) || (category == Touched) {
+		if !samePermissions(e1, e2) {
+			return Permissions
+		}
+
+		if !sameXattrs(e1, e2) {
+			return Xattrs
+		}
+	}
+
+	return category
+}
+
+// classifyContents compares two existing, readable Entries, ignoring permissions.
+func classifyContents(e1 index.Entry, e2 index.Entry) Category {
+	if e1.Type() != e2.Type() {
+		return Modified
+	}
+	if e1.IsDir() {
+		// directory times change whenever their contents do, so only existence matters
+		return Same
+	}
+	if e1.IsSymlink() {
+		// links are usually recreated by copying, so their times are not compared
+		if e1.Target() == e2.Target() {
+			return Same
+		}
+
+		return Modified
+	}
+
+	// Entry.LastMod() stored as Unix time; compare at the same precision
+	sameTime := e1.LastMod().Unix() == e2.LastMod().Unix()
+
+	if e1.Hash() == e2.Hash() {
+		if sameTime {
+			return Same
+		}
+
+		return Touched
+	}
+
+	if (e1.Size() == e2.Size()) && sameTime {
+		return Corrupted
+	}
+
+	return Modified
+}
+
+// samePermissions returns true if the permissions are the same or if either Entry did not record them.
+func samePermissions(e1 index.Entry, e2 index.Entry) bool {
+	p1, exists1 := e1.Permissions()
+	p2, exists2 := e2.Permissions()
+
+	return !exists1 || !exists2 || (p1 == p2)
+}
+
+// sameXattrs returns true if the extended attributes are the same or if either Entry did not record them.
+func sameXattrs(e1 index.Entry, e2 index.Entry) bool {
+	x1, exists1 := e1.Xattrs()
+	x2, exists2 := e2.Xattrs()
+
+	return !exists1 || !exists2 || (x1 == x2)
+}
+
+// Counts holds the number of differences in each Category.
+type Counts map[Category]int
+
+// Same returns true if there are no differences in file contents. Touched and unstable files and permission, extended
+// attribute or hard link changes are not considered different.
+func (c Counts) Same() bool {
+	return (c[Added] + c[Removed] + c[Modified] + c[Corrupted] + c[Unreadable] + c[Moved] + c[Copied]) == 0
+}
+
+// MetadataChanged returns true if any files have the same contents but a different last modification time,
+// permissions, extended attributes or hard links.
+func (c Counts) MetadataChanged() bool {
+	return (c[Touched] + c[Permissions] + c[Xattrs] + c[Links]) > 0
+}
+
+func (c Counts) String() string {
+	s := fmt.Sprintf("%d added, %d removed, %d modified, %d corrupted, %d touched", c[Added], c[Removed], c[Modified], c[Corrupted], c[Touched])
+
+	if c[Unreadable] > 0 {
+		s += fmt.Sprintf(", %d unreadable", c[Unreadable])
+	}
+
+	if c[Unstable] > 0 {
+		s += fmt.Sprintf(", %d unstable", c[Unstable])
+	}
+
+	if c[Permissions] > 0 {
+		s += fmt.Sprintf(", %d permissions", c[Permissions])
+	}
+
+	if c[Xattrs] > 0 {
+		s += fmt.Sprintf(", %d xattrs", c[Xattrs])
+	}
+
+	// only output if DetectHardLinks() found anything
+	if c[Links] > 0 {
+		s += fmt.Sprintf(", %d links", c[Links])
+	}
+
+	// only output if DetectMoves() found anything
+	if (c[Moved] + c[Copied]) > 0 {
+		s += fmt.Sprintf(", %d moved, %d copied", c[Moved], c[Copied])
+	}
+
+	return s
+}
+
+// Identity describes one of the indexes used in a comparison.
+type Identity struct {
+	Root      string
+	BaseName  string
+	Hostname  string
+	Hash      string
+	Timestamp time.Time
+}
+
+func identify(idx *index.Index) Identity {
+	id := Identity{Hostname: idx.Hostname(), Timestamp: idx.Timestamp()}
+
+	// zero value Indexes have no Config or Hasher
+	if idx.Config() != nil {
+		id.Root = idx.Config().Root()
+		id.BaseName = idx.Config().BaseName()
+	}
+
+	if idx.Hasher() != nil {
+		id.Hash = idx.Hasher().Name()
+	}
+
+	return id
+}
+
+// Difference is a single file that is not the same in two indexes.
+type Difference struct {
+	Category Category
+	Path     string
+	From     string      // original path of Moved and Copied files; the unreadable directory of files that were not found
+	One      index.Entry // zero value for Removed files and files in unreadable directories
+	Two      index.Entry // zero value for Added files and files that are only unreadable in the first index; the original Entry for Moved and Copied files
+}
+
+// CompareResult contains all the differences between two indexes.
+type CompareResult struct {
+	One         Identity
+	Two         Identity
+	Differences []Difference // sorted by path
+	Counts      Counts
+	Skipped     string // why the indexes were not compared; empty if they were
+}
+
+// SkippedResult returns a result without any Differences for indexes that could not be compared, so the result can
+// still be output. two may be nil, e.g. if there is no existing index.
+func SkippedResult(one *index.Index, two *index.Index, reason string) *CompareResult {
+	result := &CompareResult{One: identify(one), Counts: make(Counts), Skipped: reason}
+
+	if two != nil {
+		result.Two = identify(two)
+	}
+
+	return result
+}
+
+// Same returns true if there are no differences in file contents. Touched files are not considered different.
+// Indexes that were not compared are never the same.
+func (r *CompareResult) Same() bool {
+	return (r.Skipped == "") && r.Counts.Same()
+}
+
+// ByCategory returns all the Differences in the given Category, sorted by path.
+func (r *CompareResult) ByCategory(category Category) []Difference {
+	var differences []Difference
+
+	for _, d := range r.Differences {
+		if d.Category == category {
+			differences = append(differences, d)
+		}
+	}
+
+	return differences
+}
+
+// movable returns true if an Entry can be matched by its contents. Directories, unreadable files and zero byte files
+// have no distinguishing contents.
+func movable(e index.Entry) bool {
+	return (e.Hash() != "") && (e.Size() > 0)
+}
+
+// DetectMoves finds Removed and Added files with the same hash and size and replaces them with Moved files.
+// If there are more Added files than Removed files, the extra files are Copied from the last Removed file.
+// If there are more Removed files than Added files, the extra files are still Removed.
+// Moves cannot be detected if the result was created with ignoreMissing.
+func (r *CompareResult) DetectMoves() {
+	type key struct {
+		hash string
+		size int64
+	}
+
+	// Differences are sorted, so each list of paths will be sorted too
+	removed := make(map[key][]int)
+
+	for i, d := range r.Differences {
+		if (d.Category == Removed) && movable(d.Two) {
+			k := key{d.Two.Hash(), d.Two.Size()}
+			removed[k] = append(removed[k], i)
+		}
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+
+	matched := make(map[int]struct{})
+	used := make(map[key]int) // number of Removed files already matched for each key
+
+	for i, d := range r.Differences {
+		if (d.Category != Added) || !movable(d.One) {
+			continue
+		}
+
+		k := key{d.One.Hash(), d.One.Size()}
+		sources := removed[k]
+
+		if len(sources) == 0 {
+			continue
+		}
+
+		n := used[k]
+		category := Moved
+
+		if n >= len(sources) {
+			// reuse the last source for any additional copies
+			n = len(sources) - 1
+			category = Copied
+		}
+
+		source := r.Differences[sources[n]]
+		matched[sources[n]] = struct{}{}
+		used[k]++
+
+		r.Differences[i] = Difference{Category: category, Path: d.Path, From: source.Path, One: d.One, Two: source.Two}
+		r.Counts[Added]--
+		r.Counts[category]++
+	}
+
+	if len(matched) == 0 {
+		return
+	}
+
+	// remove Removed files that are now Moved
+	differences := make([]Difference, 0, len(r.Differences)-len(matched))
+
+	for i, d := range r.Differences {
+		if _, exists := matched[i]; !exists {
+			differences = append(differences, d)
+		}
+	}
+
+	r.Differences = differences
+	r.Counts[Removed] -= len(matched)
+}
+
+// DetectHardLinks adds a Links Difference for each file that has the same contents in both Indexes, but is hard linked
+// to a different group of files, e.g. a backup that copied linked files instead of preserving the links. These must be
+// the Indexes that created the result.
+func (r *CompareResult) DetectHardLinks(one *index.Index, two *index.Index) {
+	different := make(map[string]struct{}, len(r.Differences))
+
+	for _, d := range r.Differences {
+		different[d.Path] = struct{}{}
+	}
+
+	added := 0
+
+	one.ForEach(func(e1 index.Entry) {
+		// only files with the same contents; other differences are more important
+		if _, exists := different[e1.Path()]; exists {
+			return
+		}
+
+		e2, exists := two.Get(e1.Path())
+
+		if !exists || (e1.HardLink() == e2.HardLink()) {
+			return
+		}
+
+		r.Differences = append(r.Differences, Difference{Category: Links, Path: e1.Path(), One: e1, Two: e2})
+		r.Counts[Links]++
+		added++
+	})
+
+	if added > 0 {
+		sort.Slice(r.Differences, func(i, j int) bool { return r.Differences[i].Path < r.Differences[j].Path })
+	}
+}
+
+// Log outputs each Difference on its own line, followed by a summary if there were any differences.
+func (r *CompareResult) Log() {
+	now := time.Now() // use fixed now to prevent time updating when there is a lot of output
+
+	for _, d := range r.Differences {
+		log.INFO.Println(d.format(now))
+	}
+
+	if len(r.Differences) > 0 {
+		log.INFO.Println(r.Counts)
 	}
+}
 
+// CompareIndexes examines the Entries in the given Indexes and returns all the differences.
+// This function has no side effects, so multiple comparisons can run concurrently.
+func CompareIndexes(one *index.Index, two *index.Index, ignoreMissing bool) (*CompareResult, error) {
 	if (one == nil) || (two == nil) {
-		return false
+		return nil, errors.New("cannot compare a nil Index")
 	}
 
 	// allow comparison of files in different files systems; do not check for different roots
 
-	sortedPaths := sortPaths(one, two)
-	same := true
+	// hashes from different algorithms can never match
+	if one.Hasher() != two.Hasher() {
+		return nil, fmt.Errorf("cannot compare indexes with different hash algorithms: '%s' vs '%s'", one.Hasher(), two.Hasher())
+	}
+
+	result := &CompareResult{One: identify(one), Two: identify(two), Counts: make(Counts)}
 
-	// no short circuit returns in this loop to ensure that callers can track all Entries via OnMissing and OnHashChange
-	for _, path := range sortedPaths {
+	for _, path := range sortPaths(one, two) {
 		e1, exists1 := one.Get(path)
 		e2, exists2 := two.Get(path)
 
-		if !exists1 {
-			// missing from the 1st index implies a deletion; conditionally report
-			if !ignoreMissing {
-				OnMissing(e2, one)
-				same = false
+		category := Classify(e1, exists1, e2, exists2)
+		from := ""
+
+		// files under a directory that could not be read were not found, not removed
+		if category == Removed {
+			if dir, unreadable := unreadableDir(one, path); unreadable {
+				category, from = Unreadable, dir
 			}
-			continue
 		}
-		if !exists2 {
-			// missing from the 2nd index implies an addition; always report
-			OnMissing(e1, two)
-			same = false
+
+		// missing from the 1st index implies a deletion; conditionally report
+		if (category == Same) || ((category == Removed) && ignoreMissing) {
 			continue
 		}
-		// assume both cannot be missing
 
-		hash1 := e1.Hash()
-		hash2 := e2.Hash()
+		result.Differences = append(result.Differences, Difference{Category: category, Path: path, From: from, One: e1, Two: e2})
+		result.Counts[category]++
+	}
+
+	return result, nil
+}
 
-		if hash1 != hash2 {
-			OnHashChange(e1, e2)
-			same = false
+// unreadableDir returns the closest parent directory of the path that could not be read when the Index was built.
+func unreadableDir(idx *index.Index, path string) (string, bool) {
+	for dir := gopath.Dir(path); dir != "."; dir = gopath.Dir(dir) {
+		if e, exists := idx.Get(dir); exists && e.IsError() {
+			return dir, true
 		}
 	}
 
-	return same
+	return "", false
 }
 
-// MissingFn is the called when an Entry is missing from the index.
+// MissingFn is called when an Entry is missing from the index.
+//
+// Deprecated: use CompareIndexes and check the Added and Removed Differences.
 type MissingFn func(index.Entry, *index.Index)
 
 // HashFn is called when Entries do not have the same hash (i.e. they have changed).
+//
+// Deprecated: use CompareIndexes and check the Differences.
 type HashFn func(index.Entry, index.Entry)
 
-// OnMissing is the MissingFn that will be called.
-var OnMissing MissingFn
+// OnMissing is called by Compare for each Entry missing from the other index, if not nil. The default does nothing
+// since Compare logs all differences.
+//
+// Deprecated: use CompareIndexes and check the Added and Removed Differences.
+var OnMissing MissingFn = func(index.Entry, *index.Index) {}
 
-// OnHashChange is the HashFn that will be called.
-var OnHashChange HashFn
+// OnHashChange is called by Compare for each Entry in both indexes with a different hash, if not nil. The default
+// does nothing since Compare logs all differences.
+//
+// Deprecated: use CompareIndexes and check the Differences.
+var OnHashChange HashFn = func(index.Entry, index.Entry) {}
 
-func init() {
-	now := time.Now() // use fixed now to prevent time updating when there is a lot of output
+// Compare examines the Entries in the given Indexes and returns true if they are all the same.
+// All differences are logged, followed by a summary. OnMissing and OnHashChange are called for each difference, if set.
+func Compare(one *index.Index, two *index.Index, ignoreMissing bool) bool {
+	if one == two {
+		return true
+	}
 
-	// default functions for Compare
-	OnMissing = func(missing index.Entry, other *index.Index) {
-		log.INFO.Printf("! '%s': '%s' %s\n", missing.Path(), other.Config().Root(), outputTime(now, other.Timestamp()))
+	if (one == nil) || (two == nil) {
+		return false
 	}
 
-	OnHashChange = func(e1 index.Entry, e2 index.Entry) {
-		diff := e1.Size() - e2.Size()
+	result, err := CompareIndexes(one, two, ignoreMissing)
 
-		if diff != 0 {
-			comparison := ">"
+	if err != nil {
+		log.ERROR.Println(err)
+		return false
+	}
 
-			if diff < 0 {
-				diff = -diff
-				comparison = "<"
+	for _, d := range result.Differences {
+		switch {
+		case d.Category == Added:
+			if OnMissing != nil {
+				OnMissing(d.One, two)
 			}
+		case d.Category == Removed:
+			if OnMissing != nil {
+				OnMissing(d.Two, one)
+			}
+		case exists(d.One) && exists(d.Two) && (d.One.Hash() != d.Two.Hash()):
+			if OnHashChange != nil {
+				OnHashChange(d.One, d.Two)
+			}
+		}
+	}
+
+	result.Log()
+
+	return result.Same()
+}
+
+// format outputs the Difference as a single line starting with the Category's symbol
+func (d Difference) format(now time.Time) string {
+	switch d.Category {
+	case Added:
+		return fmt.Sprintf("%s '%s': %s %s", d.Category.Symbol(), d.Path, describe(d.One), outputTime(now, d.One.LastMod()))
+	case Removed:
+		return fmt.Sprintf("%s '%s': %s %s", d.Category.Symbol(), d.Path, describe(d.Two), outputTime(now, d.Two.LastMod()))
+	case Moved:
+		return fmt.Sprintf("%s '%s': moved from '%s'", d.Category.Symbol(), d.Path, d.From)
+	case Copied:
+		return fmt.Sprintf("%s '%s': copied from '%s'", d.Category.Symbol(), d.Path, d.From)
+	case Unreadable:
+		if d.From != "" {
+			return fmt.Sprintf("%s '%s': cannot read directory '%s'", d.Category.Symbol(), d.Path, d.From)
+		}
+		return fmt.Sprintf("%s '%s': cannot read file (%s)", d.Category.Symbol(), d.Path, d.One.Error())
+	case Unstable:
+		return fmt.Sprintf("%s '%s': changed while being hashed", d.Category.Symbol(), d.Path)
+	case Permissions:
+		p1, _ := d.One.Permissions()
+		p2, _ := d.Two.Permissions()
+		return fmt.Sprintf("%s '%s': %s vs %s", d.Category.Symbol(), d.Path, p1, p2)
+	case Xattrs:
+		return fmt.Sprintf("%s '%s': extended attributes differ%s", d.Category.Symbol(), d.Path, describeXattrNames(d.One, d.Two))
+	case Links:
+		return fmt.Sprintf("%s '%s': %s vs %s", d.Category.Symbol(), d.Path, describeLink(d.One), describeLink(d.Two))
+	}
+
+	if (d.Category == Modified) && !d.Two.IsError() && (d.One.Type() != d.Two.Type()) {
+		return fmt.Sprintf("%s '%s': %s vs %s", d.Category.Symbol(), d.Path, d.One.Type(), d.Two.Type())
+	}
+
+	if (d.Category == Modified) && d.One.IsSymlink() && d.Two.IsSymlink() {
+		return fmt.Sprintf("%s '%s': link to '%s' vs '%s'", d.Category.Symbol(), d.Path, d.One.Target(), d.Two.Target())
+	}
+
+	diff := d.One.Size() - d.Two.Size()
+
+	if diff != 0 {
+		comparison := ">"
+
+		if diff < 0 {
+			diff = -diff
+			comparison = "<"
+		}
+
+		return fmt.Sprintf("%s '%s': %s %s vs %s", comparison, d.Path, humanize.Bytes(uint64(diff)), outputTime(now, d.One.LastMod()), outputTime(now, d.Two.LastMod()))
+	}
+
+	return fmt.Sprintf("%s '%s': %s vs %s", d.Category.Symbol(), d.Path, outputTime(now, d.One.LastMod()), outputTime(now, d.Two.LastMod()))
+}
+
+// describe outputs the size of files or the type of other Entries
+func describe(e index.Entry) string {
+	if e.IsDir() {
+		return "directory"
+	}
+
+	if e.IsSymlink() {
+		return fmt.Sprintf("link to '%s'", e.Target())
+	}
+
+	return humanize.Bytes(uint64(e.Size()))
+}
+
+// describeXattrNames lists the extended attributes only in one Entry or the other. Empty if the names are the same,
+// i.e. only the values changed, or if either Entry has no names, since unrecorded names cannot be told apart from a
+// file without attributes.
+func describeXattrNames(e1 index.Entry, e2 index.Entry) string {
+	names1, names2 := e1.XattrNames(), e2.XattrNames()
+
+	if (len(names1) == 0) || (len(names2) == 0) {
+		return ""
+	}
+
+	var added, removed []string
 
-			log.INFO.Printf("%s '%s': %s %s vs %s\n", comparison, e1.Path(), humanize.Bytes(uint64(diff)), outputTime(now, e1.LastMod()), outputTime(now, e2.LastMod()))
-		} else {
-			log.INFO.Printf("# '%s': %s vs %s\n", e1.Path(), outputTime(now, e1.LastMod()), outputTime(now, e2.LastMod()))
+	for _, name := range names1 {
+		if !slices.Contains(names2, name) {
+			added = append(added, "'"+name+"'")
 		}
 	}
+
+	for _, name := range names2 {
+		if !slices.Contains(names1, name) {
+			removed = append(removed, "'"+name+"'")
+		}
+	}
+
+	s := ""
+
+	if len(added) > 0 {
+		s += "; added " + strings.Join(added, ", ")
+	}
+
+	if len(removed) > 0 {
+		s += "; removed " + strings.Join(removed, ", ")
+	}
+
+	return s
+}
+
+// describeLink outputs the hard link group of the Entry
+func describeLink(e index.Entry) string {
+	if e.HardLink() == "" {
+		return "not linked"
+	}
+
+	return fmt.Sprintf("linked with '%s'", e.HardLink())
 }
 
 func outputTime(now time.Time, t time.Time) string {
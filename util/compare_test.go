@@ -1,9 +1,15 @@
 package util
 
 import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/file"
 	"github.com/hpresnall/yabrc/index"
 	"github.com/hpresnall/yabrc/test"
 	log "github.com/spf13/jwalterweatherman"
@@ -46,6 +52,80 @@ func TestCompareDifferentRoots(t *testing.T) {
 	}
 }
 
+func TestCompareDifferentHashers(t *testing.T) {
+	idx1 := IndexForTest(t)
+
+	cfg := *idx1.Config()
+	cfg.SetHash("sha512")
+
+	idx2, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	if Compare(idx1, idx2, false) {
+		t.Error("indexes with different hash algorithms should not be equal")
+	}
+}
+
+func TestCompareIndexesNil(t *testing.T) {
+	if _, err := CompareIndexes(nil, &index.Index{}, false); err == nil {
+		t.Error("should not be able to compare nil index")
+	}
+}
+
+func TestCompareIndexesDifferentHashers(t *testing.T) {
+	idx1 := IndexForTest(t)
+
+	cfg := *idx1.Config()
+	cfg.SetHash("sha1")
+
+	idx2, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	if _, err = CompareIndexes(idx1, idx2, false); err == nil {
+		t.Error("should not be able to compare indexes with different hash algorithms")
+	}
+}
+
+func TestCompareConcurrent(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	test.MakeFile(t, root+"/test4/test4_1", "data4_1", 0644)
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	results := make(chan *CompareResult, 4)
+
+	for range cap(results) {
+		go func() {
+			result, _ := CompareIndexes(idx2, idx1, false)
+			results <- result
+		}()
+	}
+
+	for range cap(results) {
+		result := <-results
+
+		if (result == nil) || (len(result.Differences) != 1) || (result.Counts[Added] != 1) {
+			t.Error("each comparison should find 1 added file", result)
+		}
+
+		if (result.One.Root != root) || (result.Two.Hash != idx1.Hasher().Name()) {
+			t.Error("result should identify the indexes", result.One, result.Two)
+		}
+	}
+}
+
 func TestCompareSame(t *testing.T) {
 	idx := IndexForTest(t)
 
@@ -54,6 +134,30 @@ func TestCompareSame(t *testing.T) {
 	}
 }
 
+func TestCountsUnstable(t *testing.T) {
+	counts := Counts{Unstable: 2}
+
+	if !counts.Same() {
+		t.Error("unstable files should not be a difference")
+	}
+
+	if !strings.Contains(counts.String(), "2 unstable") {
+		t.Error("summary should include unstable files", counts)
+	}
+}
+
+func TestCountsMetadataChanged(t *testing.T) {
+	if (Counts{Added: 1, Modified: 1}).MetadataChanged() {
+		t.Error("content changes are not metadata changes")
+	}
+
+	for _, c := range []Category{Touched, Permissions, Xattrs, Links} {
+		if counts := (Counts{c: 1}); !counts.Same() || !counts.MetadataChanged() {
+			t.Errorf("%s should be a metadata change", c)
+		}
+	}
+}
+
 func TestCompareEqual(t *testing.T) {
 	idx1 := IndexForTest(t)
 	idx2 := IndexForTest(t)
@@ -94,16 +198,18 @@ func TestCompare(t *testing.T) {
 	comparisons["test2/"+"test2_1"] = struct{}{}
 	comparisons["test4/"+"test4_1"] = struct{}{}
 
+	// deprecated callbacks are still called
+	called := make(map[string]struct{})
 	oldMissing := OnMissing
 	oldHash := OnHashChange
 
 	OnMissing = func(missing index.Entry, other *index.Index) {
-		delete(comparisons, missing.Path())
+		called[missing.Path()] = struct{}{}
 		oldMissing(missing, other)
 	}
 
 	OnHashChange = func(e1 index.Entry, e2 index.Entry) {
-		delete(comparisons, e1.Path())
+		called[e1.Path()] = struct{}{}
 		oldHash(e1, e2)
 	}
 
@@ -116,17 +222,384 @@ func TestCompare(t *testing.T) {
 		t.Error("indexes should not be equal")
 	}
 
+	for path := range comparisons {
+		if _, exists := called[path]; !exists {
+			t.Errorf("callback should be called for '%s'", path)
+		}
+	}
+
+	result, err := CompareIndexes(idx1, idx2, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	for _, d := range result.Differences {
+		delete(comparisons, d.Path)
+	}
+
 	if len(comparisons) != 0 {
 		t.Error("not all comparison cases were detected", comparisons)
 	}
 
-	// run again with ignoreMissing; Compare should ignore test5 since it is only in idx2
-	comparisons["test4/"+"test4_1"] = struct{}{}
+	// run again with ignoreMissing; Compare should ignore test4 since it is only in idx2
+	result, err = CompareIndexes(idx1, idx2, true)
 
-	if Compare(idx1, idx2, true) {
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	if result.Same() {
 		t.Error("indexes should not be equal when ignoreMissing is true")
 	}
-	if len(comparisons) != 1 {
-		t.Error("ignoreMissing did not ignore missing file")
+
+	for _, d := range result.Differences {
+		if d.Path == "test4/"+"test4_1" {
+			t.Error("ignoreMissing did not ignore missing file")
+		}
+	}
+
+	if len(result.ByCategory(Removed)) != 0 {
+		t.Error("ignoreMissing should not report removed files")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	idx := IndexForTest(t)
+	root := idx.Config().Root()
+
+	original, _ := idx.Get("test1/test1_1")
+	now := time.Now()
+
+	// build Entries via the Index for each case
+	entryFor := func(data string, modTime time.Time) index.Entry {
+		info := test.MakeFile(t, root+"/test1/test1_1", data, 0644)
+		file.GetFs().Chtimes(root+"/test1/test1_1", modTime, modTime)
+		info, _ = file.GetFs().Stat(root + "/test1/test1_1")
+
+		e, err := idx.BuildEntry(root+"/test1/test1_1", info, idx.Hasher().New())
+
+		if err != nil {
+			t.Fatal("should be able to build entry", err)
+		}
+
+		return e
+	}
+
+	same := entryFor("data1_1", original.LastMod())
+	corrupted := entryFor("data1_x", original.LastMod())
+	modifiedSameSize := entryFor("data1_x", now.Add(time.Hour))
+	modified := entryFor("data1_1 updated", original.LastMod())
+	touched := entryFor("data1_1", now.Add(time.Hour))
+	unreadable, err := idx.BuildErrorEntry(root+"/test1/test1_1", nil, fs.ErrPermission)
+
+	if err != nil {
+		t.Fatal("should be able to build error entry", err)
+	}
+
+	unstable, err := idx.BuildErrorEntry(root+"/test1/test1_1", nil, index.ErrUnstable)
+
+	if err != nil {
+		t.Fatal("should be able to build error entry", err)
+	}
+
+	dirInfo, _ := file.GetFs().Stat(root + "/test1")
+	dir, err := idx.BuildDirEntry(root+"/test1", dirInfo)
+
+	if err != nil {
+		t.Fatal("should be able to build dir entry", err)
+	}
+
+	file.GetFs().Chtimes(root+"/test1", now.Add(time.Hour), now.Add(time.Hour))
+	dirInfo, _ = file.GetFs().Stat(root + "/test1")
+	touchedDir, _ := idx.BuildDirEntry(root+"/test1", dirInfo)
+
+	cases := []struct {
+		e1       index.Entry
+		exists1  bool
+		e2       index.Entry
+		exists2  bool
+		expected Category
+	}{
+		{same, true, original, true, Same},
+		{same, false, original, true, Removed},
+		{same, true, original, false, Added},
+		{corrupted, true, original, true, Corrupted},
+		{modifiedSameSize, true, original, true, Modified},
+		{modified, true, original, true, Modified},
+		{touched, true, original, true, Touched},
+		{unreadable, true, original, true, Unreadable},
+		{unreadable, true, original, false, Unreadable},
+		{unreadable, true, unreadable, true, Unreadable},
+		{unreadable, false, original, true, Removed},
+		{unstable, true, original, true, Unstable},
+		{unstable, true, original, false, Unstable},
+		{same, true, unstable, true, Modified},
+		{same, true, unreadable, true, Modified},
+		{dir, true, dir, true, Same},
+		{dir, true, touchedDir, true, Same},
+		{dir, true, original, true, Modified},
+		{same, true, dir, true, Modified},
+		{dir, true, original, false, Added},
+	}
+
+	for i, c := range cases {
+		if category := Classify(c.e1, c.exists1, c.e2, c.exists2); category != c.expected {
+			t.Errorf("%d: category should be %s, not %s", i, c.expected, category)
+		}
+	}
+}
+
+func TestCompareUnreadable(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	test.SetupErrorFs(t, map[string]error{
+		root + "/test2/sub1/test2_sub1_2": &fs.PathError{Op: "read", Path: "test2_sub1_2", Err: syscall.EIO},
+		root + "/test3":                   fs.ErrPermission,
+	})
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index with unreadable files", err)
+	}
+
+	// unreadable directory is recorded, but its files are not
+	if idx2.Size() != idx1.Size() {
+		t.Error("unreadable files should be in the index", idx2.StringWithEntries())
+	}
+
+	if e, _ := idx2.Get("test2/sub1/test2_sub1_2"); e.Error() != index.ErrorIO {
+		t.Error("file should be unreadable due to an I/O error", e)
+	}
+
+	if e, _ := idx2.Get("test3"); e.Error() != index.ErrorPermission {
+		t.Error("directory should be unreadable due to permissions", e)
+	}
+
+	result, err := CompareIndexes(idx2, idx1, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	// files in the unreadable directory were not found, but are not removed
+	if (result.Counts[Unreadable] != 3) || (result.Counts[Removed] != 0) || result.Same() {
+		t.Error("should have 3 unreadable and no removed files", result.Counts)
+	}
+
+	if d := result.ByCategory(Unreadable); (len(d) != 3) || (d[2].Path != "test3/test3") || (d[2].From != "test3") || (d[2].format(time.Now()) != "! 'test3/test3': cannot read directory 'test3'") {
+		t.Error("file in the unreadable directory should list the directory", d)
+	}
+
+	var buffer bytes.Buffer
+	result.WriteJSON(&buffer)
+
+	if !strings.Contains(buffer.String(), `"from": "test3",
+      "one": null,`) {
+		t.Error("JSON should list the directory without an entry for the file", buffer.String())
+	}
+
+	if !strings.Contains(result.Counts.String(), "3 unreadable") {
+		t.Error("summary should include unreadable files", result.Counts)
+	}
+
+	// unreadable files should be retried, not reused, even if they have not changed
+	file.SetFs(file.GetFs().(test.ErrorFs).Fs)
+
+	again, err := BuildIndex(idx2.Config(), idx2)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	if e, _ := again.Get("test2/sub1/test2_sub1_2"); e.IsError() {
+		t.Error("file should be readable", e)
+	}
+
+	// for coverage
+	result.Log()
+}
+
+func TestCompareCategories(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	// touch a file without changing its contents
+	updated := time.Now().Add(time.Hour)
+	file.GetFs().Chtimes(root+"/test1/test1_1", updated, updated)
+
+	// change a file without changing its size or time
+	entry, _ := idx1.Get("test2/test2_1")
+	test.MakeFile(t, root+"/test2/test2_1", "data2_x", 0644)
+	file.GetFs().Chtimes(root+"/test2/test2_1", entry.LastMod(), entry.LastMod())
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	if Compare(idx2, idx1, false) {
+		t.Error("indexes should not be equal")
+	}
+
+	result, err := CompareIndexes(idx2, idx1, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	counts := result.Counts
+
+	if (counts[Touched] != 1) || (counts[Corrupted] != 1) || (len(counts) != 2) {
+		t.Error("should have 1 touched and 1 corrupted file", counts)
+	}
+
+	if counts.String() == "" {
+		t.Error("counts should have a String()")
+	}
+}
+
+func TestCompareTouchedOnly(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	updated := time.Now().Add(time.Hour)
+	file.GetFs().Chtimes(root+"/test1/test1_1", updated, updated)
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	if !Compare(idx2, idx1, false) {
+		t.Error("indexes with only touched files should be equal")
+	}
+}
+
+func TestDetectMoves(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	// move test3 => 2 copies
+	test.RemoveDir(t, root+"/test3")
+	test.MakeFile(t, root+"/moved/test3", "data3", 0644)
+	test.MakeFile(t, root+"/moved/test3_copy", "data3", 0644)
+
+	// unrelated addition and removal
+	test.MakeFile(t, root+"/test4/test4_1", "data4_1", 0644)
+	file.GetFs().Remove(root + "/test1/test1_1")
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	result, err := CompareIndexes(idx2, idx1, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	if (result.Counts[Added] != 3) || (result.Counts[Removed] != 2) {
+		t.Fatal("should have 3 added and 2 removed before detecting moves", result.Counts)
+	}
+
+	result.DetectMoves()
+
+	if (result.Counts[Added] != 1) || (result.Counts[Removed] != 1) || (result.Counts[Moved] != 1) || (result.Counts[Copied] != 1) {
+		t.Error("should have 1 added, 1 removed, 1 moved and 1 copied", result.Counts)
+	}
+
+	if len(result.Differences) != 4 {
+		t.Error("should have 4 differences", result.Differences)
+	}
+
+	for _, d := range result.Differences {
+		switch d.Category {
+		case Moved:
+			if (d.Path != "moved/test3") || (d.From != "test3/test3") {
+				t.Error("incorrect move", d)
+			}
+		case Copied:
+			if (d.Path != "moved/test3_copy") || (d.From != "test3/test3") {
+				t.Error("incorrect copy", d)
+			}
+		case Removed:
+			if d.Path != "test1/test1_1" {
+				t.Error("incorrect removal", d)
+			}
+		}
+	}
+
+	if !strings.Contains(result.Counts.String(), "1 moved") {
+		t.Error("summary should include moves", result.Counts)
+	}
+
+	// for coverage
+	result.Log()
+}
+
+func TestDetectMovesExtraRemoved(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	// 2 identical files => 1 file
+	test.MakeFile(t, root+"/test3/test3_copy", "data3", 0644)
+
+	idx1, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	test.RemoveDir(t, root+"/test3")
+	test.MakeFile(t, root+"/moved/test3", "data3", 0644)
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	result, err := CompareIndexes(idx2, idx1, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	result.DetectMoves()
+
+	if (result.Counts[Moved] != 1) || (result.Counts[Removed] != 1) || (result.Counts[Added] != 0) {
+		t.Error("should have 1 moved and 1 removed", result.Counts)
+	}
+}
+
+func TestDetectMovesNone(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	test.MakeFile(t, root+"/test4/test4_1", "data4_1", 0644)
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	result, err := CompareIndexes(idx2, idx1, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	result.DetectMoves()
+
+	if (result.Counts[Added] != 1) || (len(result.Differences) != 1) {
+		t.Error("should only have 1 added file", result.Counts)
 	}
 }
@@ -0,0 +1,279 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/afero"
+	log "github.com/spf13/jwalterweatherman"
+
+	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/index"
+)
+
+// DuplicateFile is a single file in a DuplicateGroup.
+type DuplicateFile struct {
+	Root  string   `json:"root"`
+	Path  string   `json:"path"`            // relative to Root
+	Links []string `json:"links,omitempty"` // other paths hard linked to this file, relative to Root
+
+	order int // position of the file's index in the arguments to FindDuplicates
+}
+
+// FullPath returns the file's path including the root.
+func (f DuplicateFile) FullPath() string {
+	return joinRoot(f.Root, f.Path)
+}
+
+// LinkPaths returns the paths hard linked to the file, including the root.
+func (f DuplicateFile) LinkPaths() []string {
+	paths := make([]string, len(f.Links))
+
+	for i, link := range f.Links {
+		paths[i] = joinRoot(f.Root, link)
+	}
+
+	return paths
+}
+
+// joinRoot adds the root to the relative path without doubling the separator when the root is "/".
+func joinRoot(root string, relative string) string {
+	return strings.TrimSuffix(root, "/") + "/" + relative
+}
+
+// DuplicateGroup is a set of files with the same contents.
+type DuplicateGroup struct {
+	Hash  string          `json:"hash"`
+	Size  int64           `json:"size"`
+	Files []DuplicateFile `json:"files"` // in index order, then sorted by path
+}
+
+// Wasted returns the number of bytes that could be reclaimed by keeping only one file in the group. Hard linked paths
+// share their data, so they count as a single file.
+func (g DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Files)-1)
+}
+
+// FindDuplicates groups all the Entries in the given indexes by their hash and size.
+// Hard linked Entries in the same index are a single file, listed under the first path of the link group.
+// Files that were already found, through overlapping roots, the same index given twice or followed symbolic links, are
+// only listed once, so removing the other files in a group never removes the only copy.
+// Only groups with more than one file are returned, sorted by the most wasted bytes first.
+// All the indexes must use the same hash algorithm.
+func FindDuplicates(indexes ...*index.Index) ([]DuplicateGroup, error) {
+	if len(indexes) == 0 {
+		return nil, errors.New("at least one Index is required")
+	}
+
+	type key struct {
+		hash string
+		size int64
+	}
+
+	groups := make(map[key]*DuplicateGroup)
+
+	// for each index, the paths of each hard link group, keyed by the first path
+	links := make([]map[string][]string, len(indexes))
+
+	// actual locations of all the files found so far
+	seen := make(map[string]bool)
+
+	for n, idx := range indexes {
+		if idx == nil {
+			return nil, errors.New("cannot find duplicates in a nil Index")
+		}
+
+		if idx.Hasher() != indexes[0].Hasher() {
+			return nil, fmt.Errorf("cannot find duplicates in indexes with different hash algorithms: '%s' vs '%s'", indexes[0].Hasher(), idx.Hasher())
+		}
+
+		root := idx.Config().Root()
+		links[n] = make(map[string][]string)
+
+		// sort so the same paths are found first on every run
+		var entries []index.Entry
+		idx.ForEach(func(e index.Entry) { entries = append(entries, e) })
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path() < entries[j].Path() })
+
+		for _, e := range entries {
+			// no hash to compare; all empty files are the same but waste no space
+			if e.IsError() || !e.IsFile() || (e.Size() == 0) {
+				continue
+			}
+
+			actual := realPath(joinRoot(root, e.Path()))
+
+			if seen[actual] {
+				continue
+			}
+
+			seen[actual] = true
+
+			// only the first path of each link group is added
+			if (e.HardLink() != "") && (e.HardLink() != e.Path()) {
+				links[n][e.HardLink()] = append(links[n][e.HardLink()], e.Path())
+				continue
+			}
+
+			k := key{e.Hash(), e.Size()}
+			group, exists := groups[k]
+
+			if !exists {
+				group = &DuplicateGroup{Hash: e.Hash(), Size: e.Size()}
+				groups[k] = group
+			}
+
+			group.Files = append(group.Files, DuplicateFile{Root: root, Path: e.Path(), order: n})
+		}
+	}
+
+	var duplicates []DuplicateGroup
+
+	for _, group := range groups {
+		if len(group.Files) < 2 {
+			continue
+		}
+
+		for i, f := range group.Files {
+			if linked := links[f.order][f.Path]; len(linked) > 0 {
+				sort.Strings(linked)
+				group.Files[i].Links = linked
+			}
+		}
+
+		// keep index order but sort paths within each index
+		sort.Slice(group.Files, func(i, j int) bool {
+			if group.Files[i].order != group.Files[j].order {
+				return group.Files[i].order < group.Files[j].order
+			}
+			return group.Files[i].Path < group.Files[j].Path
+		})
+
+		duplicates = append(duplicates, *group)
+	}
+
+	// most wasted first; break ties by path for consistent output
+	sort.Slice(duplicates, func(i, j int) bool {
+		wi, wj := duplicates[i].Wasted(), duplicates[j].Wasted()
+
+		if wi != wj {
+			return wi > wj
+		}
+		return duplicates[i].Files[0].FullPath() < duplicates[j].Files[0].FullPath()
+	})
+
+	return duplicates, nil
+}
+
+// realPath returns the actual location of the file on the operating system's file system, with all symbolic links
+// resolved. Otherwise, or if the path cannot be resolved, the cleaned path is returned.
+func realPath(p string) string {
+	if _, ok := file.GetFs().(*afero.OsFs); ok {
+		if abs, err := filepath.Abs(p); err == nil {
+			if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+				return resolved
+			}
+
+			return abs
+		}
+	}
+
+	return path.Clean(p)
+}
+
+// TotalWasted returns the number of bytes wasted by all the groups.
+func TotalWasted(groups []DuplicateGroup) int64 {
+	total := int64(0)
+
+	for _, g := range groups {
+		total += g.Wasted()
+	}
+
+	return total
+}
+
+// LogDuplicates outputs each group followed by a summary.
+func LogDuplicates(groups []DuplicateGroup) {
+	for _, g := range groups {
+		log.INFO.Printf("%d copies of %s; %s wasted; %s\n", len(g.Files), humanize.Bytes(uint64(g.Size)), humanize.Bytes(uint64(g.Wasted())), g.Hash)
+
+		for _, f := range g.Files {
+			log.INFO.Printf("  '%s'\n", f.FullPath())
+
+			for _, link := range f.LinkPaths() {
+				log.INFO.Printf("    linked as '%s'\n", link)
+			}
+		}
+	}
+
+	log.INFO.Printf("%d duplicate groups; %s wasted\n", len(groups), humanize.Bytes(uint64(TotalWasted(groups))))
+}
+
+// WriteDuplicatesJSON writes all the groups and the total wasted bytes as a single JSON object.
+func WriteDuplicatesJSON(w io.Writer, groups []DuplicateGroup) error {
+	type jsonGroup struct {
+		DuplicateGroup
+		Wasted int64 `json:"wasted"`
+	}
+
+	out := struct {
+		Groups []jsonGroup `json:"groups"`
+		Wasted int64       `json:"wasted"`
+	}{
+		Groups: make([]jsonGroup, len(groups)),
+		Wasted: TotalWasted(groups),
+	}
+
+	for i, g := range groups {
+		out.Groups[i] = jsonGroup{g, g.Wasted()}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(out)
+}
+
+// WriteDuplicatesScript writes a shell script that removes all but the first file in each group. The data is only
+// reclaimed once every hard link to a file is removed, so all of its links are removed too.
+// The kept path is never removed, even if it is listed again in the group.
+// The script is only a suggestion; it is never run by yabrc and should be reviewed before use.
+func WriteDuplicatesScript(w io.Writer, groups []DuplicateGroup) error {
+	var b strings.Builder
+
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# generated by yabrc; suggested removals of duplicate files\n")
+	b.WriteString("# REVIEW CAREFULLY BEFORE RUNNING\n")
+	fmt.Fprintf(&b, "# %d duplicate groups; %s wasted\n", len(groups), humanize.Bytes(uint64(TotalWasted(groups))))
+
+	for _, g := range groups {
+		fmt.Fprintf(&b, "\n# %d copies of %s; %s\n", len(g.Files), humanize.Bytes(uint64(g.Size)), g.Hash)
+		// newlines in a comment would end it, so escape them
+		keep := g.Files[0].FullPath()
+		fmt.Fprintf(&b, "# keep %s\n", strings.ReplaceAll(shellQuote(keep), "\n", `\n`))
+
+		for _, f := range g.Files[1:] {
+			for _, p := range append([]string{f.FullPath()}, f.LinkPaths()...) {
+				if realPath(p) != realPath(keep) {
+					fmt.Fprintf(&b, "rm -- %s\n", shellQuote(p))
+				}
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// shellQuote single quotes the string so it is not interpreted by the shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,278 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestFindDuplicatesNone(t *testing.T) {
+	if _, err := FindDuplicates(); err == nil {
+		t.Error("should not be able to find duplicates without an index")
+	}
+
+	if _, err := FindDuplicates(nil); err == nil {
+		t.Error("should not be able to find duplicates in a nil index")
+	}
+
+	groups, err := FindDuplicates(IndexForTest(t))
+
+	if err != nil {
+		t.Fatal("should be able to find duplicates", err)
+	}
+
+	if len(groups) != 0 {
+		t.Error("should not find any duplicates", groups)
+	}
+}
+
+func TestFindDuplicatesEmpty(t *testing.T) {
+	config := *IndexForTest(t).Config()
+	config.SetRecordEmpty(true)
+
+	// another zero byte file and an empty directory
+	test.MakeFile(t, config.Root()+"/test1/empty", "", 0644)
+	test.MakeDir(t, config.Root()+"/test3/empty")
+
+	idx, err := BuildIndex(&config, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	groups, err := FindDuplicates(idx)
+
+	if err != nil {
+		t.Fatal("should be able to find duplicates", err)
+	}
+
+	if len(groups) != 0 {
+		t.Error("zero byte files and directories should not be duplicates", groups)
+	}
+}
+
+func duplicatesForTest(t *testing.T) (*index.Index, *index.Index) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	// 3 copies of a large file, 2 copies of a small file
+	test.MakeFile(t, root+"/dupes/large1", "large data", 0644)
+	test.MakeFile(t, root+"/dupes/large2", "large data", 0644)
+	test.MakeFile(t, root+"/dupes/it's large", "large data", 0644)
+	test.MakeFile(t, root+"/dupes/small", "data3", 0644) // same as test3/test3
+
+	idx1, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	// second index with a different root and a copy of the small file
+	cfg, err := config.FromString(t, "root: otherRoot\nbaseName: otherBaseName")
+
+	if err != nil {
+		t.Fatal("should be able to load config", err)
+	}
+
+	test.MakeFile(t, "otherRoot/small", "data3", 0644)
+
+	idx2, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	return idx1, idx2
+}
+
+func TestFindDuplicates(t *testing.T) {
+	idx1, idx2 := duplicatesForTest(t)
+
+	groups, err := FindDuplicates(idx1, idx2)
+
+	if err != nil {
+		t.Fatal("should be able to find duplicates", err)
+	}
+
+	if len(groups) != 2 {
+		t.Fatal("should find 2 groups of duplicates", groups)
+	}
+
+	// most wasted first
+	if (len(groups[0].Files) != 3) || (groups[0].Wasted() != 20) {
+		t.Error("first group should be 3 large files wasting 20 bytes", groups[0])
+	}
+
+	if groups[0].Files[0].Path != "dupes/it's large" {
+		t.Error("files should be sorted by path", groups[0].Files)
+	}
+
+	if (len(groups[1].Files) != 3) || (groups[1].Wasted() != 10) {
+		t.Error("second group should be 3 small files wasting 10 bytes", groups[1])
+	}
+
+	// files from the second index should be last
+	if groups[1].Files[2].FullPath() != "otherRoot/small" {
+		t.Error("files should be in index order", groups[1].Files)
+	}
+
+	if TotalWasted(groups) != 30 {
+		t.Error("total wasted should be 30, not", TotalWasted(groups))
+	}
+
+	// for coverage
+	LogDuplicates(groups)
+}
+
+func TestFindDuplicatesDifferentHashers(t *testing.T) {
+	idx1 := IndexForTest(t)
+
+	cfg := *idx1.Config()
+	cfg.SetHash("crc32c")
+
+	idx2, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	if _, err = FindDuplicates(idx1, idx2); err == nil {
+		t.Error("should not be able to find duplicates with different hash algorithms")
+	}
+}
+
+func TestFindDuplicatesOverlapping(t *testing.T) {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	test.MakeFile(t, root+"/dupes/large1", "large data", 0644)
+	test.MakeFile(t, root+"/dupes/large2", "large data", 0644)
+	test.MakeFile(t, root+"/dupes/large3", "large data", 0644)
+	test.MakeFile(t, root+"/dupes/small", "data3", 0644) // same as test3/test3
+
+	idx1, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	// the same index twice and a root inside the first one
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"/dupes\nbaseName: dupes")
+
+	if err != nil {
+		t.Fatal("should be able to load config", err)
+	}
+
+	idx2, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	groups, err := FindDuplicates(idx1, idx1, idx2)
+
+	if err != nil {
+		t.Fatal("should be able to find duplicates", err)
+	}
+
+	// same as the first index alone
+	if (len(groups) != 2) || (len(groups[0].Files) != 3) || (len(groups[1].Files) != 2) {
+		t.Fatal("each file should only be listed once", groups)
+	}
+
+	for _, g := range groups {
+		for _, f := range g.Files {
+			if f.Root != idx1.Config().Root() {
+				t.Error("files should only be listed for the first index", f)
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	WriteDuplicatesScript(&buffer, groups)
+
+	if strings.Count(buffer.String(), "\nrm -- ") != 3 {
+		t.Error("script should remove 3 files", buffer.String())
+	}
+}
+
+func TestDuplicateFullPath(t *testing.T) {
+	f := DuplicateFile{Root: "/", Path: "p", Links: []string{"l"}}
+
+	if f.FullPath() != "/p" {
+		t.Error("root should not be doubled, not", f.FullPath())
+	}
+
+	if links := f.LinkPaths(); (len(links) != 1) || (links[0] != "/l") {
+		t.Error("root should not be doubled in links, not", links)
+	}
+
+	f.Root = "root"
+
+	if f.FullPath() != "root/p" {
+		t.Error("incorrect path", f.FullPath())
+	}
+}
+
+func TestWriteDuplicatesJSON(t *testing.T) {
+	idx1, idx2 := duplicatesForTest(t)
+	groups, _ := FindDuplicates(idx1, idx2)
+
+	var buffer bytes.Buffer
+
+	if err := WriteDuplicatesJSON(&buffer, groups); err != nil {
+		t.Fatal("should be able to write JSON", err)
+	}
+
+	var parsed struct {
+		Groups []struct {
+			Hash   string          `json:"hash"`
+			Wasted int64           `json:"wasted"`
+			Files  []DuplicateFile `json:"files"`
+		} `json:"groups"`
+		Wasted int64 `json:"wasted"`
+	}
+
+	if err := json.Unmarshal(buffer.Bytes(), &parsed); err != nil {
+		t.Fatal("should be able to parse JSON", err, buffer.String())
+	}
+
+	if (len(parsed.Groups) != 2) || (parsed.Wasted != 30) || (parsed.Groups[0].Wasted != 20) || (len(parsed.Groups[0].Files) != 3) {
+		t.Error("incorrect JSON", buffer.String())
+	}
+}
+
+func TestWriteDuplicatesScript(t *testing.T) {
+	idx1, idx2 := duplicatesForTest(t)
+	groups, _ := FindDuplicates(idx1, idx2)
+
+	var buffer bytes.Buffer
+
+	if err := WriteDuplicatesScript(&buffer, groups); err != nil {
+		t.Fatal("should be able to write script", err)
+	}
+
+	script := buffer.String()
+
+	if !strings.HasPrefix(script, "#!/bin/sh\n") {
+		t.Error("script should start with #!", script)
+	}
+
+	// first file in each group is kept
+	if !strings.Contains(script, `# keep 'testRoot/dupes/it'\''s large'`) {
+		t.Error("script should keep the first file with quotes escaped", script)
+	}
+
+	if strings.Count(script, "\nrm -- ") != 4 {
+		t.Error("script should remove 4 files", script)
+	}
+
+	if !strings.Contains(script, "rm -- 'otherRoot/small'\n") {
+		t.Error("script should remove file from the second index", script)
+	}
+}
@@ -0,0 +1,170 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/hpresnall/yabrc/index"
+)
+
+// JSON representation of a CompareResult
+type jsonResult struct {
+	One         *jsonIdentity    `json:"one"`
+	Two         *jsonIdentity    `json:"two"` // nil if there is no second index
+	Skipped     string           `json:"skipped,omitempty"`
+	Differences []jsonDifference `json:"differences"`
+	Summary     map[string]any   `json:"summary"`
+}
+
+type jsonIdentity struct {
+	Root      string `json:"root"`
+	BaseName  string `json:"baseName"`
+	Hostname  string `json:"hostname"`
+	Hash      string `json:"hash"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type jsonDifference struct {
+	Path     string     `json:"path"`
+	Category string     `json:"category"`
+	From     string     `json:"from,omitempty"`
+	One      *jsonEntry `json:"one"` // nil for Removed files and files in unreadable directories
+	Two      *jsonEntry `json:"two"` // nil for Added files and files that are only unreadable in the first index
+}
+
+type jsonEntry struct {
+	Size       int64    `json:"size"`
+	LastMod    *int64   `json:"lastMod"` // null if unknown, e.g. for unreadable files
+	Hash       string   `json:"hash"`
+	Error      string   `json:"error,omitempty"`  // only for unreadable files
+	Type       string   `json:"type,omitempty"`   // only for directories and symbolic links
+	Target     string   `json:"target,omitempty"` // only for symbolic links
+	Mode       string   `json:"mode,omitempty"`   // only if permissions were recorded
+	UID        *int     `json:"uid,omitempty"`
+	GID        *int     `json:"gid,omitempty"`
+	Xattrs     string   `json:"xattrs,omitempty"`     // only if extended attributes were recorded
+	XattrNames []string `json:"xattrNames,omitempty"` // only if extended attribute names were recorded
+	HardLink   string   `json:"hardLink,omitempty"`   // only for hard linked files
+}
+
+// WriteJSON writes the result as a single JSON object containing both index identities, all the differences and
+// a summary of the counts for each Category. Results that were skipped include the reason.
+func (r *CompareResult) WriteJSON(w io.Writer) error {
+	out := jsonResult{
+		One:         toJSONIdentity(r.One),
+		Two:         toJSONIdentity(r.Two),
+		Skipped:     r.Skipped,
+		Differences: make([]jsonDifference, len(r.Differences)),
+		Summary:     make(map[string]any, len(Categories)+1),
+	}
+
+	for i, d := range r.Differences {
+		out.Differences[i] = jsonDifference{Path: d.Path, Category: d.Category.String(), From: d.From}
+
+		if (d.Category != Removed) && exists(d.One) {
+			out.Differences[i].One = toJSONEntry(d.One)
+		}
+		if (d.Category != Added) && exists(d.Two) {
+			out.Differences[i].Two = toJSONEntry(d.Two)
+		}
+	}
+
+	for _, c := range Categories {
+		out.Summary[c.String()] = r.Counts[c]
+	}
+
+	out.Summary["same"] = r.Same()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(out)
+}
+
+func toJSONIdentity(id Identity) *jsonIdentity {
+	if id == (Identity{}) {
+		return nil
+	}
+
+	return &jsonIdentity{Root: id.Root, BaseName: id.BaseName, Hostname: id.Hostname, Hash: id.Hash, Timestamp: id.Timestamp.Unix()}
+}
+
+func toJSONEntry(e index.Entry) *jsonEntry {
+	entry := &jsonEntry{Size: e.Size(), Hash: e.Hash(), Error: e.Error()}
+
+	if !e.LastMod().IsZero() {
+		lastMod := e.LastMod().Unix()
+		entry.LastMod = &lastMod
+	}
+
+	if !e.IsFile() {
+		entry.Type = e.Type()
+		entry.Target = e.Target()
+	}
+
+	if p, exists := e.Permissions(); exists {
+		entry.Mode = p.Octal()
+		entry.UID = &p.UID
+		entry.GID = &p.GID
+	}
+
+	if x, exists := e.Xattrs(); exists {
+		entry.Xattrs = x
+		entry.XattrNames = e.XattrNames()
+	}
+
+	entry.HardLink = e.HardLink()
+
+	return entry
+}
+
+// exists returns false for the zero value Entry used when a file is not in one of the indexes.
+func exists(e index.Entry) bool {
+	return e.Path() != ""
+}
+
+// formatLastMod returns the Unix time or an empty string if it is unknown, e.g. for unreadable files.
+func formatLastMod(e index.Entry) string {
+	if e.LastMod().IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(e.LastMod().Unix(), 10)
+}
+
+// the CSV header written by WriteCSV
+var csvHeader = []string{"path", "category", "size1", "size2", "lastMod1", "lastMod2", "hash1", "hash2", "from"}
+
+// WriteCSV writes the differences as CSV with a header line. Fields for a missing Entry are left empty.
+func (r *CompareResult) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+
+	if err := out.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, d := range r.Differences {
+		record := []string{d.Path, d.Category.String(), "", "", "", "", "", "", d.From}
+
+		if (d.Category != Removed) && exists(d.One) {
+			record[2] = strconv.FormatInt(d.One.Size(), 10)
+			record[4] = formatLastMod(d.One)
+			record[6] = d.One.Hash()
+		}
+		if (d.Category != Added) && exists(d.Two) {
+			record[3] = strconv.FormatInt(d.Two.Size(), 10)
+			record[5] = formatLastMod(d.Two)
+			record[7] = d.Two.Hash()
+		}
+
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
@@ -0,0 +1,169 @@
+package util
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/fs"
+	"testing"
+
+	"github.com/hpresnall/yabrc/test"
+)
+
+func resultForTest(t *testing.T) *CompareResult {
+	idx1 := IndexForTest(t)
+	root := idx1.Config().Root()
+
+	test.MakeFile(t, root+"/test1/test1_1", "1", 0644)                  // modified
+	test.RemoveDir(t, root+"/test3")                                    // removed
+	test.MakeFile(t, root+"/test4/test4_1", "data4_1", 0644)            // added
+	test.MakeFile(t, root+"/test2/sub1/comma,path", "comma,path", 0644) // added; needs quoting
+
+	idx2, err := BuildIndex(idx1.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build index", err)
+	}
+
+	result, err := CompareIndexes(idx2, idx1, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	return result
+}
+
+func TestWriteJSON(t *testing.T) {
+	result := resultForTest(t)
+
+	var buffer bytes.Buffer
+
+	if err := result.WriteJSON(&buffer); err != nil {
+		t.Fatal("should be able to write JSON", err)
+	}
+
+	var parsed jsonResult
+
+	if err := json.Unmarshal(buffer.Bytes(), &parsed); err != nil {
+		t.Fatal("should be able to parse JSON", err, buffer.String())
+	}
+
+	if len(parsed.Differences) != 4 {
+		t.Fatal("should have 4 differences", parsed.Differences)
+	}
+
+	for _, d := range parsed.Differences {
+		switch d.Category {
+		case "added":
+			if (d.One == nil) || (d.Two != nil) {
+				t.Error("added files should only have the first entry", d)
+			}
+		case "removed":
+			if (d.One != nil) || (d.Two == nil) {
+				t.Error("removed files should only have the second entry", d)
+			}
+		case "modified":
+			if (d.One == nil) || (d.Two == nil) || (d.One.Size == d.Two.Size) || (d.One.Hash == d.Two.Hash) {
+				t.Error("modified files should have both entries", d)
+			}
+		default:
+			t.Error("unexpected category", d.Category)
+		}
+	}
+
+	if (parsed.Summary["added"] != float64(2)) || (parsed.Summary["same"] != false) {
+		t.Error("summary should have 2 added and not be the same", parsed.Summary)
+	}
+
+	if parsed.One.Root != result.One.Root {
+		t.Error("first index should be identified", parsed.One)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	result := resultForTest(t)
+
+	var buffer bytes.Buffer
+
+	if err := result.WriteCSV(&buffer); err != nil {
+		t.Fatal("should be able to write CSV", err)
+	}
+
+	records, err := csv.NewReader(&buffer).ReadAll()
+
+	if err != nil {
+		t.Fatal("should be able to parse CSV", err)
+	}
+
+	if len(records) != 5 {
+		t.Fatal("should have a header and 4 records", records)
+	}
+
+	for i, field := range csvHeader {
+		if records[0][i] != field {
+			t.Errorf("header field %d should be '%s', not '%s'", i, field, records[0][i])
+		}
+	}
+
+	found := false
+
+	for _, record := range records[1:] {
+		if record[0] == "test2/sub1/comma,path" {
+			found = true
+
+			if (record[1] != "added") || (record[2] != "10") || (record[3] != "") {
+				t.Error("incorrect added record", record)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("should have record for path with a comma", records)
+	}
+}
+
+func TestWriteUnreadable(t *testing.T) {
+	idx := IndexForTest(t)
+
+	// unreadable file that is not in the second index and has no FileInfo
+	e, err := idx.BuildErrorEntry(idx.Config().Root()+"/new", nil, fs.ErrPermission)
+
+	if err != nil {
+		t.Fatal("should be able to build error entry", err)
+	}
+
+	result := &CompareResult{Differences: []Difference{{Category: Unreadable, Path: "new", One: e}}, Counts: Counts{Unreadable: 1}}
+
+	var buffer bytes.Buffer
+
+	if err := result.WriteJSON(&buffer); err != nil {
+		t.Fatal("should be able to write JSON", err)
+	}
+
+	var parsed jsonResult
+
+	if err := json.Unmarshal(buffer.Bytes(), &parsed); err != nil {
+		t.Fatal("should be able to parse JSON", err, buffer.String())
+	}
+
+	if d := parsed.Differences[0]; (d.One == nil) || (d.One.LastMod != nil) || (d.Two != nil) {
+		t.Error("unknown lastMod and missing second entry should be null", buffer.String())
+	}
+
+	buffer.Reset()
+
+	if err := result.WriteCSV(&buffer); err != nil {
+		t.Fatal("should be able to write CSV", err)
+	}
+
+	records, err := csv.NewReader(&buffer).ReadAll()
+
+	if err != nil {
+		t.Fatal("should be able to parse CSV", err)
+	}
+
+	if record := records[1]; (record[3] != "") || (record[4] != "") || (record[5] != "") {
+		t.Error("unknown lastMod and missing second entry should be empty", record)
+	}
+}
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/spf13/jwalterweatherman"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/file"
+)
+
+// IgnoreFileName is the name of the files that list paths to skip in their directory and all subdirectories.
+// These files use gitignore syntax.
+const IgnoreFileName = ".yabrcignore"
+
+// ignoreRule is a single line from an ignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp // matches paths relative to the ignore file's directory
+	negate  bool           // '!' re-includes a previously ignored path
+	dirOnly bool           // trailing '/' only matches directories
+}
+
+// ignoreRules holds the rules from every ignore file found while walking, keyed by directory.
+type ignoreRules map[string][]ignoreRule
+
+// load reads the ignore file in the given directory, if any. Invalid lines are skipped with a warning so a single bad
+// file does not stop the build.
+func (r ignoreRules) load(dir string) {
+	ignoreFile := filepath.Join(dir, IgnoreFileName)
+	f, err := file.GetFs().Open(ignoreFile)
+
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.WARN.Printf("cannot read '%s': %v\n", ignoreFile, err)
+		}
+		return
+	}
+
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{}
+
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+		}
+
+		rule.re, err = config.GlobToRegexp(norm.NFC.String(line))
+
+		if err != nil {
+			log.WARN.Printf("skipping invalid line '%s' in '%s': %v\n", line, ignoreFile, err)
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err = scanner.Err(); err != nil {
+		log.WARN.Printf("cannot read '%s': %v\n", ignoreFile, err)
+	}
+
+	if len(rules) > 0 {
+		log.DEBUG.Printf("loaded %d rules from '%s'\n", len(rules), ignoreFile)
+		r[filepath.Clean(dir)] = rules
+	}
+}
+
+// ignored returns true if the given path is ignored by the rules from its parent directories, up to the root.
+// As with gitignore, the last matching rule wins and rules in deeper directories override those in the root.
+func (r ignoreRules) ignored(root string, path string, isDir bool) bool {
+	if len(r) == 0 {
+		return false
+	}
+
+	// parents, from the immediate parent up to the root
+	var dirs []string
+
+	for dir := filepath.Dir(path); len(dir) >= len(root); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := r[dirs[i]]
+
+		if len(rules) == 0 {
+			continue
+		}
+
+		relative, err := filepath.Rel(dirs[i], path)
+
+		if err != nil {
+			continue
+		}
+
+		relative = norm.NFC.String(filepath.ToSlash(relative))
+
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+
+			if rule.re.MatchString(relative) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
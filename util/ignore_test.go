@@ -0,0 +1,62 @@
+package util
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestBuildIndexIgnoreFiles(t *testing.T) {
+	cfg := IndexForTest(t).Config()
+	root := cfg.Root()
+
+	test.MakeFile(t, root+"/"+IgnoreFileName, "# build outputs\n\n*.log\n!keep.log\nbuild/\n/test3\n[invalid\n", 0644)
+	test.MakeFile(t, root+"/test2/"+IgnoreFileName, "!debug.log\nsub1/*_2\n", 0644)
+	test.MakeFile(t, root+"/test1/a.log", "a.log", 0644)
+	test.MakeFile(t, root+"/test1/keep.log", "keep.log", 0644)
+	test.MakeFile(t, root+"/test2/debug.log", "debug.log", 0644)
+	test.MakeFile(t, root+"/test2/build/output", "output", 0644)
+	test.MakeFile(t, root+"/test2/sub2/test3", "test3", 0644)
+
+	idx, err := BuildIndex(cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	for _, path := range []string{IgnoreFileName, "test2/" + IgnoreFileName, "test1/test1_1", "test1/keep.log", "test2/debug.log", "test2/sub1/test2_sub1_1", "test2/sub2/test3"} {
+		if _, exists := idx.Get(path); !exists {
+			t.Errorf("'%s' should be indexed", path)
+		}
+	}
+
+	for _, path := range []string{"test1/a.log", "test2/build/output", "test3/test3", "test2/sub1/test2_sub1_2"} {
+		if _, exists := idx.Get(path); exists {
+			t.Errorf("'%s' should be ignored", path)
+		}
+	}
+
+	if idx.Size() != 8 {
+		t.Error("Index should have 8 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+}
+
+func TestBuildIndexUnreadableIgnoreFile(t *testing.T) {
+	cfg := IndexForTest(t).Config()
+	ignoreFile := cfg.Root() + "/" + IgnoreFileName
+
+	test.MakeFile(t, ignoreFile, "*", 0644)
+	test.SetupErrorFs(t, map[string]error{ignoreFile: fs.ErrPermission})
+
+	idx, err := BuildIndex(cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// the other files are still indexed; the ignore file itself is recorded as unreadable
+	if idx.Size() != 6 {
+		t.Error("Index should have 6 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+}
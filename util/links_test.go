@@ -0,0 +1,186 @@
+//go:build unix
+// +build unix
+
+package util
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/test"
+)
+
+// builds an Index on the real file system
+// layout: a/file1, b/link1 & link2 hard linked to a/file1, copy, a copy of a/file1
+func hardLinkIndexForTest(t *testing.T) (*config.Config, string) {
+	root := test.SetupOsFs(t)
+
+	test.MakeDir(t, root+"/a")
+	test.MakeDir(t, root+"/b")
+	test.MakeFile(t, root+"/a/file1", "data1", 0644)
+	test.MakeFile(t, root+"/copy", "data1", 0644)
+
+	for _, link := range []string{"/b/link1", "/link2"} {
+		if err := os.Link(root+"/a/file1", root+link); err != nil {
+			t.Skip("hard links are not supported by the temp directory", err)
+		}
+	}
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	return &cfg, root
+}
+
+func TestBuildIndexHardLinks(t *testing.T) {
+	cfg, _ := hardLinkIndexForTest(t)
+
+	idx, err := BuildIndex(cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// rebuilding reuses the Entries but must still group them
+	idx2, err := BuildIndex(cfg, idx)
+
+	if err != nil {
+		t.Fatal("should be able to rebuild the Index", err)
+	}
+
+	for _, idx := range []*index.Index{idx, idx2} {
+		original, _ := idx.Get("a/file1")
+
+		for _, path := range []string{"a/file1", "b/link1", "link2"} {
+			e, exists := idx.Get(path)
+
+			if !exists || (e.HardLink() != "a/file1") || (e.Hash() != original.Hash()) || !e.IsValid() {
+				t.Error("hard link should be grouped with 'a/file1'", e)
+			}
+		}
+
+		if e, _ := idx.Get("copy"); e.HardLink() != "" {
+			t.Error("copy should not be linked", e)
+		}
+	}
+}
+
+func TestDetectHardLinks(t *testing.T) {
+	cfg, root := hardLinkIndexForTest(t)
+
+	idx, err := BuildIndex(cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// replace a link with a copy that has the same modification time
+	info, _ := os.Stat(root + "/link2")
+	os.Remove(root + "/link2")
+	test.MakeFile(t, root+"/link2", "data1", 0644)
+	os.Chtimes(root+"/link2", info.ModTime(), info.ModTime())
+
+	idx2, err := BuildIndex(cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to rebuild the Index", err)
+	}
+
+	result, err := CompareIndexes(idx2, idx, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	if len(result.Differences) != 0 {
+		t.Error("hard links should not be compared by default", result.Differences)
+	}
+
+	result.DetectHardLinks(idx2, idx)
+
+	d := result.ByCategory(Links)
+
+	if !result.Same() || (len(d) != 1) || (d[0].Path != "link2") || (result.Counts[Links] != 1) {
+		t.Fatal("only link2 should be reported", result.Differences)
+	}
+
+	if d[0].format(info.ModTime()) != "| 'link2': not linked vs linked with 'a/file1'" {
+		t.Error("incorrect output", d[0].format(info.ModTime()))
+	}
+}
+
+func TestFindDuplicatesHardLinks(t *testing.T) {
+	cfg, root := hardLinkIndexForTest(t)
+
+	idx, err := BuildIndex(cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	groups, err := FindDuplicates(idx)
+
+	if err != nil {
+		t.Fatal("should be able to find duplicates", err)
+	}
+
+	// links share the data with a/file1, so only copy is wasted
+	if (len(groups) != 1) || (len(groups[0].Files) != 2) || (groups[0].Wasted() != 5) {
+		t.Fatal("hard links should be a single file", groups)
+	}
+
+	if f := groups[0].Files[0]; (f.Path != "a/file1") || (len(f.Links) != 2) || (f.Links[0] != "b/link1") || (f.Links[1] != "link2") {
+		t.Error("first file should list its links", f)
+	}
+
+	// the same index twice lists each file once; the kept file is never removed
+	groups, _ = FindDuplicates(idx, idx)
+
+	var buffer bytes.Buffer
+
+	if err := WriteDuplicatesScript(&buffer, groups); err != nil {
+		t.Fatal("should be able to write script", err)
+	}
+
+	script := buffer.String()
+
+	if (len(groups) != 1) || (len(groups[0].Files) != 2) || (strings.Count(script, "\nrm -- ") != 1) || strings.Contains(script, "rm -- '"+root+"/a/file1'") {
+		t.Error("script should only remove the copy", script)
+	}
+
+	// another index first; removing a/file1 must remove all its links
+	other := test.SetupOsFs(t)
+	test.MakeFile(t, other+"/first", "data1", 0644)
+
+	otherCfg, err := config.FromStringKeepFs(t, "root: "+other+"\nbaseName: other")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	otherIdx, err := BuildIndex(&otherCfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	groups, _ = FindDuplicates(otherIdx, idx)
+	buffer.Reset()
+
+	if err := WriteDuplicatesScript(&buffer, groups); err != nil {
+		t.Fatal("should be able to write script", err)
+	}
+
+	script = buffer.String()
+
+	if (strings.Count(script, "\nrm -- ") != 4) || (strings.Count(script, "rm -- '"+root+"/b/link1'\n") != 1) {
+		t.Error("script should remove the copies and every link of the removed file", script)
+	}
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestBuildIndexPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are only recorded on Unix")
+	}
+
+	root := test.SetupOsFs(t)
+
+	test.MakeDir(t, root+"/a")
+	test.MakeFile(t, root+"/a/file1", "data1", 0644)
+	test.MakeFile(t, root+"/file2", "data2", 0600)
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test\nrecordPermissions: true")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	idx, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	e, _ := idx.Get("a/file1")
+
+	if p, exists := e.Permissions(); !exists || (p.Octal() != "0644") || (p.UID != os.Getuid()) || (p.GID != os.Getgid()) {
+		t.Error("permissions should be recorded", e)
+	}
+
+	if err = os.Chmod(root+"/file2", 0640); err != nil {
+		t.Fatal("cannot chmod file", err)
+	}
+
+	// fast rebuilds reuse the hash but must pick up the new mode
+	for _, existing := range []bool{false, true} {
+		var idx2 *index.Index
+
+		if existing {
+			idx2, err = BuildIndex(&cfg, idx)
+		} else {
+			idx2, err = BuildIndex(&cfg, nil)
+		}
+
+		if err != nil {
+			t.Fatal("should be able to rebuild Index", err)
+		}
+
+		e, _ = idx2.Get("file2")
+
+		if p, _ := e.Permissions(); p.Octal() != "0640" {
+			t.Error("updated permissions should be recorded", e)
+		}
+
+		result, err := CompareIndexes(idx2, idx, false)
+
+		if err != nil {
+			t.Fatal("should be able to compare", err)
+		}
+
+		if !result.Same() || !result.Counts.MetadataChanged() || (result.Counts[Permissions] != 1) || (result.Counts[Modified] != 0) {
+			t.Error("only permissions should differ", result.Counts)
+		}
+
+		if d := result.ByCategory(Permissions); (len(d) != 1) || (d[0].Path != "file2") {
+			t.Error("file2 permissions should differ", d)
+		}
+	}
+
+	cfg.SetRecordPermissions(false)
+	idx3, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to rebuild Index", err)
+	}
+
+	e, _ = idx3.Get("file2")
+
+	if _, exists := e.Permissions(); exists {
+		t.Error("permissions should not be recorded when disabled", e)
+	}
+
+	// permissions are not compared unless both indexes recorded them
+	if result, _ := CompareIndexes(idx3, idx, false); len(result.Differences) != 0 {
+		t.Error("indexes without permissions should be the same", result.Differences)
+	}
+}
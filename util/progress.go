@@ -0,0 +1,108 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	log "github.com/spf13/jwalterweatherman"
+)
+
+// Progress is a snapshot of an Index build.
+type Progress struct {
+	Files         int           // files hashed
+	Bytes         int64         // bytes hashed
+	SkippedFiles  int           // files reused from an existing index or checkpoint without hashing
+	SkippedBytes  int64         // bytes in the skipped files
+	CurrentDir    string        // directory of the most recently completed file, relative to the root
+	Elapsed       time.Duration // time since the build started
+	ExpectedBytes int64         // total bytes expected in the Index; zero if unknown
+}
+
+// BytesPerSec returns the hashing throughput.
+func (p Progress) BytesPerSec() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time remaining. Returns false if the expected total is unknown, has already been
+// exceeded or nothing has been hashed yet.
+func (p Progress) ETA() (time.Duration, bool) {
+	remaining := p.ExpectedBytes - p.Bytes - p.SkippedBytes
+	rate := p.BytesPerSec()
+
+	if (p.ExpectedBytes <= 0) || (remaining <= 0) || (rate <= 0) {
+		return 0, false
+	}
+
+	return time.Duration(float64(remaining) / rate * float64(time.Second)), true
+}
+
+func (p Progress) String() string {
+	s := fmt.Sprintf("%d files, %s hashed; %s/sec", p.Files, humanize.Bytes(uint64(p.Bytes)), humanize.Bytes(uint64(p.BytesPerSec())))
+
+	if p.SkippedFiles > 0 {
+		s += fmt.Sprintf("; %d skipped", p.SkippedFiles)
+	}
+
+	if eta, ok := p.ETA(); ok {
+		s += fmt.Sprintf("; %v remaining", eta.Round(time.Second))
+	}
+
+	return s + "; in '" + p.CurrentDir + "'"
+}
+
+// ProgressReporter receives periodic updates while an Index is built.
+// Both functions are called from a single goroutine, so implementations do not need to be safe for concurrent use.
+type ProgressReporter interface {
+	// Progress is called periodically while the build is running.
+	Progress(p Progress)
+	// Done is called once when the build completes or is interrupted.
+	Done(p Progress)
+}
+
+// LogProgress outputs progress as INFO log lines.
+type LogProgress struct{}
+
+func (LogProgress) Progress(p Progress) {
+	log.INFO.Println(p)
+}
+
+func (LogProgress) Done(Progress) {
+	// BuildIndex always logs a summary
+}
+
+// TerminalProgress outputs progress to a terminal as a single line that is continually overwritten.
+type TerminalProgress struct {
+	w io.Writer
+}
+
+// NewTerminalProgress creates a TerminalProgress that writes to the given terminal.
+func NewTerminalProgress(w io.Writer) *TerminalProgress {
+	return &TerminalProgress{w}
+}
+
+func (t *TerminalProgress) Progress(p Progress) {
+	// return to the start of the line and clear it
+	fmt.Fprintf(t.w, "\r\033[K%v", p)
+}
+
+func (t *TerminalProgress) Done(Progress) {
+	fmt.Fprint(t.w, "\r\033[K")
+}
+
+// IsTerminal returns true if the given file is a terminal rather than a pipe or a regular file.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+
+	if err != nil {
+		return false
+	}
+
+	return (info.Mode() & os.ModeCharDevice) != 0
+}
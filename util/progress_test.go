@@ -0,0 +1,116 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// records all the calls to a ProgressReporter
+type testProgress struct {
+	updates []Progress
+	done    []Progress
+}
+
+func (t *testProgress) Progress(p Progress) {
+	t.updates = append(t.updates, p)
+}
+
+func (t *testProgress) Done(p Progress) {
+	t.done = append(t.done, p)
+}
+
+func TestBuildIndexProgress(t *testing.T) {
+	idx := IndexForTest(t)
+	reporter := &testProgress{}
+
+	_, err := BuildIndexWithOptions(idx.Config(), BuildOptions{Existing: idx, Progress: reporter, ProgressInterval: time.Millisecond, ExpectedBytes: 100})
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	if len(reporter.done) != 1 {
+		t.Fatal("Done should be called once, not", len(reporter.done))
+	}
+
+	p := reporter.done[0]
+
+	// all files reused from the existing index
+	if (p.Files != 0) || (p.SkippedFiles != idx.Size()) || (p.SkippedBytes != 40) || (p.ExpectedBytes != 100) {
+		t.Error("incorrect progress", p)
+	}
+
+	if p.Elapsed <= 0 {
+		t.Error("elapsed time should be set", p.Elapsed)
+	}
+}
+
+func TestBuildIndexProgressHashed(t *testing.T) {
+	idx := IndexForTest(t)
+	reporter := &testProgress{}
+
+	_, err := BuildIndexWithOptions(idx.Config(), BuildOptions{Progress: reporter})
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	p := reporter.done[0]
+
+	if (p.Files != idx.Size()) || (p.Bytes != 40) || (p.SkippedFiles != 0) {
+		t.Error("incorrect progress", p)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	p := Progress{Files: 1, Bytes: 100, SkippedBytes: 100, Elapsed: time.Second * 10, CurrentDir: "dir"}
+
+	if _, ok := p.ETA(); ok {
+		t.Error("should not have an ETA without expected bytes")
+	}
+
+	p.ExpectedBytes = 300
+
+	// 100 bytes remaining at 10 bytes / sec
+	if eta, ok := p.ETA(); !ok || (eta != time.Second*10) {
+		t.Error("ETA should be 10s, not", eta)
+	}
+
+	if !strings.Contains(p.String(), "10s remaining") {
+		t.Error("output should include ETA", p)
+	}
+
+	p.ExpectedBytes = 150
+
+	if _, ok := p.ETA(); ok {
+		t.Error("should not have an ETA when expected bytes are exceeded")
+	}
+
+	if (Progress{}).BytesPerSec() != 0 {
+		t.Error("throughput should be 0 with no elapsed time")
+	}
+}
+
+func TestTerminalProgress(t *testing.T) {
+	var buffer bytes.Buffer
+	terminal := NewTerminalProgress(&buffer)
+
+	terminal.Progress(Progress{Files: 2, CurrentDir: "test"})
+
+	if !strings.HasPrefix(buffer.String(), "\r") || !strings.Contains(buffer.String(), "2 files") {
+		t.Errorf("output should overwrite the line: %q", buffer.String())
+	}
+
+	buffer.Reset()
+	terminal.Done(Progress{})
+
+	if strings.TrimSpace(buffer.String()) != "\033[K" {
+		t.Errorf("Done should clear the line: %q", buffer.String())
+	}
+
+	// for coverage
+	LogProgress{}.Progress(Progress{})
+	LogProgress{}.Done(Progress{})
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"sort"
+
+	"github.com/hpresnall/yabrc/index"
+)
+
+// selectScrub chooses which unchanged Entries to rehash. Entries verified longest ago are chosen first, with unknown
+// verification times first of all, until the given fraction of the Index's total bytes or the given number of bytes
+// is reached, whichever is larger. Returns the relative paths of the chosen Entries.
+func selectScrub(idx *index.Index, fraction float64, bytes int64) map[string]struct{} {
+	if (idx == nil) || ((fraction <= 0) && (bytes <= 0)) {
+		return nil
+	}
+
+	entries := make([]index.Entry, 0, idx.Size())
+	total := int64(0)
+
+	idx.ForEach(func(e index.Entry) {
+		// always rehashed or nothing to hash
+		if e.IsError() || !e.IsFile() {
+			return
+		}
+
+		entries = append(entries, e)
+		total += e.Size()
+	})
+
+	budget := max(int64(float64(total)*min(fraction, 1)), bytes)
+
+	// oldest first; break ties by path so runs with the same data choose the same files
+	sort.Slice(entries, func(i, j int) bool {
+		vi, vj := entries[i].Verified(), entries[j].Verified()
+
+		if !vi.Equal(vj) {
+			return vi.Before(vj)
+		}
+		return entries[i].Path() < entries[j].Path()
+	})
+
+	selected := make(map[string]struct{})
+	selectedBytes := int64(0)
+
+	// always include the Entry that reaches the budget so large files are eventually verified
+	for _, e := range entries {
+		if selectedBytes >= budget {
+			break
+		}
+
+		selected[e.Path()] = struct{}{}
+		selectedBytes += e.Size()
+	}
+
+	return selected
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestSelectScrub(t *testing.T) {
+	idx := IndexForTest(t)
+
+	if selectScrub(nil, 1, 0) != nil {
+		t.Error("should not select from a nil Index")
+	}
+
+	if selectScrub(idx, 0, 0) != nil {
+		t.Error("should not select without a limit")
+	}
+
+	if len(selectScrub(idx, 1, 0)) != idx.Size() {
+		t.Error("should select all Entries")
+	}
+
+	if len(selectScrub(idx, 2, 0)) != idx.Size() {
+		t.Error("should select all Entries when the fraction is too large")
+	}
+
+	// all verified at the same time, so select by path
+	// first file is 7 bytes, second is 12
+	selected := selectScrub(idx, 0, 10)
+
+	if len(selected) != 2 {
+		t.Fatal("should select 2 Entries, not", len(selected))
+	}
+
+	for _, path := range []string{"test1/test1_1", "test2/sub1/test2_sub1_1"} {
+		if _, exists := selected[path]; !exists {
+			t.Errorf("'%s' should be selected: %v", path, selected)
+		}
+	}
+
+	// 25% of 40 bytes; larger of the two limits
+	if len(selectScrub(idx, 0.25, 1)) != 2 {
+		t.Error("should select 2 Entries")
+	}
+}
+
+func TestBuildIndexScrub(t *testing.T) {
+	idx := IndexForTest(t)
+	root := idx.Config().Root()
+
+	// change the contents of every file without changing the size or time
+	corrupt := map[string]string{
+		"test1/test1_1":           "DATA1_1",
+		"test2/test2_1":           "DATA2_1",
+		"test2/sub1/test2_sub1_1": "DATA2_SUB1_1",
+		"test2/sub1/test2_sub1_2": "DATA2_1_2",
+		"test3/test3":             "DATA3",
+	}
+
+	for path, data := range corrupt {
+		entry, _ := idx.Get(path)
+		test.MakeFile(t, root+"/"+path, data, 0644)
+		file.GetFs().Chtimes(root+"/"+path, entry.LastMod(), entry.LastMod())
+	}
+
+	scrubbed, err := BuildIndexWithOptions(idx.Config(), BuildOptions{Existing: idx, ScrubBytes: 10})
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// only scrubbed files should be rehashed
+	result, err := CompareIndexes(scrubbed, idx, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	if result.Counts[Corrupted] != 2 {
+		t.Fatal("scrub should find 2 corrupted files, not", result.Counts[Corrupted])
+	}
+
+	for _, d := range result.Differences {
+		if (d.Path != "test1/test1_1") && (d.Path != "test2/sub1/test2_sub1_1") {
+			t.Error("unexpected difference", d.Path)
+		}
+	}
+}
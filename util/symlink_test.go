@@ -0,0 +1,147 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/test"
+)
+
+// builds an Index on the real file system with the given symlinks setting
+// layout: a/file1, a/link_file -> file1, link_dir -> a, a/loop -> .., broken -> missing
+func symlinkIndexForTest(t *testing.T, symlinks string) *index.Index {
+	root := test.SetupOsFs(t)
+
+	test.MakeDir(t, root+"/a")
+	test.MakeFile(t, root+"/a/file1", "data1", 0644)
+	test.MakeSymlink(t, "file1", root+"/a/link_file")
+	test.MakeSymlink(t, "a", root+"/link_dir")
+	test.MakeSymlink(t, "..", root+"/a/loop")
+	test.MakeSymlink(t, "missing", root+"/broken")
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test\nsymlinks: "+symlinks)
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	idx, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	return idx
+}
+
+func TestBuildIndexSkipSymlinks(t *testing.T) {
+	idx := symlinkIndexForTest(t, config.SymlinksSkip)
+
+	if _, exists := idx.Get("a/file1"); !exists || (idx.Size() != 1) {
+		t.Error("Index should only contain the file", idx.StringWithEntries())
+	}
+}
+
+func TestBuildIndexRecordSymlinks(t *testing.T) {
+	idx := symlinkIndexForTest(t, config.SymlinksRecord)
+
+	links := map[string]string{"a/link_file": "file1", "link_dir": "a", "a/loop": "..", "broken": "missing"}
+
+	for path, target := range links {
+		e, exists := idx.Get(path)
+
+		if !exists || !e.IsSymlink() || (e.Target() != target) {
+			t.Errorf("'%s' should be a link to '%s', not %v", path, target, e)
+		}
+	}
+
+	if idx.Size() != 5 {
+		t.Error("Index should have 5 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+
+	// changing a target is a modification; replacing a link with a copy is too
+	root := idx.Config().Root()
+	file.GetFs().Remove(root + "/link_dir")
+	test.MakeSymlink(t, "a/loop", root+"/link_dir")
+	file.GetFs().Remove(root + "/a/link_file")
+	test.MakeFile(t, root+"/a/link_file", "data1", 0644)
+
+	newIdx, err := BuildIndex(idx.Config(), idx)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	result, err := CompareIndexes(newIdx, idx, false)
+
+	if err != nil {
+		t.Fatal("should be able to compare", err)
+	}
+
+	if (result.Counts[Modified] != 2) || (result.Counts[Added] != 0) || (result.Counts[Removed] != 0) {
+		t.Error("should have 2 modified, not", result.Counts)
+	}
+}
+
+func TestBuildIndexFollowSymlinks(t *testing.T) {
+	idx := symlinkIndexForTest(t, config.SymlinksFollow)
+
+	file1, _ := idx.Get("a/file1")
+
+	// loops and broken links are skipped
+	for _, path := range []string{"a/link_file", "link_dir/file1", "link_dir/link_file"} {
+		e, exists := idx.Get(path)
+
+		if !exists || !e.IsFile() || (e.Hash() != file1.Hash()) {
+			t.Errorf("'%s' should be a copy of 'a/file1', not %v", path, e)
+		}
+	}
+
+	if idx.Size() != 4 {
+		t.Error("Index should have 4 entries, not", idx.Size(), idx.StringWithEntries())
+	}
+}
+
+func TestFindDuplicatesFollowSymlinks(t *testing.T) {
+	idx := symlinkIndexForTest(t, config.SymlinksFollow)
+
+	// every path is a link to a/file1, so there is only one copy
+	groups, err := FindDuplicates(idx)
+
+	if err != nil {
+		t.Fatal("should be able to find duplicates", err)
+	}
+
+	if len(groups) != 0 {
+		t.Fatal("files reached through links should not be duplicates", groups)
+	}
+
+	root := idx.Config().Root()
+	test.MakeFile(t, root+"/copy", "data1", 0644)
+
+	idx, err = BuildIndex(idx.Config(), nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	groups, _ = FindDuplicates(idx)
+
+	if (len(groups) != 1) || (len(groups[0].Files) != 2) || (groups[0].Files[0].Path != "a/file1") || (groups[0].Files[1].Path != "copy") {
+		t.Fatal("only the copy should be a duplicate", groups)
+	}
+
+	var buffer bytes.Buffer
+
+	if err := WriteDuplicatesScript(&buffer, groups); err != nil {
+		t.Fatal("should be able to write script", err)
+	}
+
+	if script := buffer.String(); (strings.Count(script, "\nrm -- ") != 1) || !strings.Contains(script, "rm -- '"+root+"/copy'\n") {
+		t.Error("script should only remove the copy", script)
+	}
+}
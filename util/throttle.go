@@ -0,0 +1,91 @@
+package util
+
+import (
+	"context"
+	"hash"
+	"sync"
+	"time"
+)
+
+// limiter paces work to a maximum rate per second. It is shared by all the workers hashing files.
+type limiter struct {
+	mu   sync.Mutex
+	rate float64   // units per second
+	next time.Time // time when all previously reserved work will be complete at the given rate
+}
+
+// newLimiter returns nil if rate is not positive; a nil limiter never waits.
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &limiter{rate: rate}
+}
+
+// wait blocks until n units of work can be done without exceeding the rate or the context is cancelled. If cancelled,
+// the work is not reserved, so other callers are not delayed by it.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+
+	now := time.Now()
+
+	// do not allow idle time to accumulate into a burst
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	reserved := time.Duration(float64(n) / l.rate * float64(time.Second))
+	l.next = l.next.Add(reserved)
+	delay := l.next.Sub(now)
+
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.next = l.next.Add(-reserved)
+		l.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// throttledHash limits the rate that file data is hashed, which also limits the rate that data is read.
+// Waiting stops once its context is cancelled.
+type throttledHash struct {
+	hash.Hash
+	limiter *limiter
+	ctx     context.Context
+}
+
+func (t throttledHash) Write(p []byte) (int, error) {
+	if err := t.limiter.wait(t.ctx, len(p)); err != nil {
+		return 0, err
+	}
+
+	return t.Hash.Write(p)
+}
+
+// cancellableHash stops hashing once its context is cancelled, so large files do not delay shutdown.
+type cancellableHash struct {
+	hash.Hash
+	ctx context.Context
+}
+
+func (c cancellableHash) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.Hash.Write(p)
+}
@@ -0,0 +1,121 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	// nil limiter should not block
+	var l *limiter = newLimiter(0)
+
+	if l != nil {
+		t.Fatal("limiter should be nil for a rate of 0")
+	}
+
+	l.wait(context.Background(), 100)
+
+	l = newLimiter(10000)
+	start := time.Now()
+
+	for range 3 {
+		l.wait(context.Background(), 1000)
+	}
+
+	// 3000 units at 10000 / sec
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Error("limiter should wait at least 300ms, not", d)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := newLimiter(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// 1000 units at 10 / sec would take 100s
+	if err := l.wait(ctx, 1000); err != context.DeadlineExceeded {
+		t.Error("wait should stop when cancelled, not", err)
+	}
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Error("wait should return once cancelled, not after", d)
+	}
+
+	// the cancelled work should not delay later work
+	start = time.Now()
+	l.wait(context.Background(), 1)
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Error("cancelled work should be released, but waited", d)
+	}
+}
+
+func TestThrottledHash(t *testing.T) {
+	data := []byte("data to hash")
+
+	h := throttledHash{sha256.New(), newLimiter(1000000), context.Background()}
+	h.Write(data)
+
+	expected := sha256.Sum256(data)
+
+	if !bytes.Equal(h.Sum(nil), expected[:]) {
+		t.Error("throttled hash should not change the hash value")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	h = throttledHash{sha256.New(), newLimiter(1), ctx}
+
+	if _, err := h.Write(data); err != context.Canceled {
+		t.Error("should not wait after cancel, not", err)
+	}
+}
+
+func TestCancellableHash(t *testing.T) {
+	data := []byte("data to hash")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := cancellableHash{sha256.New(), ctx}
+
+	if _, err := h.Write(data); err != nil {
+		t.Error("should write before cancel", err)
+	}
+
+	cancel()
+
+	if _, err := h.Write(data); err != context.Canceled {
+		t.Error("should not write after cancel, not", err)
+	}
+}
+
+func TestBuildIndexThrottled(t *testing.T) {
+	idx := IndexForTest(t)
+	cfg := *idx.Config()
+
+	cfg.SetMaxReadRate("100")
+	cfg.SetMaxFileRate(50)
+
+	start := time.Now()
+
+	throttled, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	// 40 bytes at 100 bytes / sec
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Error("build should take at least 400ms, not", d)
+	}
+
+	if throttled.Size() != idx.Size() {
+		t.Error("throttled Index should have the same size", throttled.Size(), idx.Size())
+	}
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"errors"
+
+	"github.com/hpresnall/yabrc/index"
+)
+
+// Verify rehashes all the files under the given Index's root and compares them to the Index's Entries.
+// The live file system is the first index in the result. Nothing is written to the file system.
+func Verify(idx *index.Index) (*CompareResult, error) {
+	if idx == nil {
+		return nil, errors.New("cannot verify a nil Index")
+	}
+
+	// hash with the same algorithm as the Index, regardless of the current config
+	config := *idx.Config()
+	config.SetHash(idx.Hasher().Name())
+
+	live, err := BuildIndex(&config, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return CompareIndexes(live, idx, false)
+}
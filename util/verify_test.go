@@ -0,0 +1,77 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hpresnall/yabrc/file"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func TestVerifyNil(t *testing.T) {
+	if _, err := Verify(nil); err == nil {
+		t.Error("should not be able to verify a nil Index")
+	}
+}
+
+func TestVerifySame(t *testing.T) {
+	idx := IndexForTest(t)
+
+	result, err := Verify(idx)
+
+	if err != nil {
+		t.Fatal("should be able to verify", err)
+	}
+
+	if !result.Same() {
+		t.Error("should have no differences", result.Counts)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	idx := IndexForTest(t)
+	root := idx.Config().Root()
+
+	// same size, same time, different contents => corrupted
+	path := "test2/sub1/test2_sub1_2"
+	entry, _ := idx.Get(path)
+	test.MakeFile(t, root+"/"+path, "data2_1_x", 0644)
+	file.GetFs().Chtimes(root+"/"+path, entry.LastMod(), entry.LastMod())
+
+	// same size, newer time, different contents => modified
+	path = "test2/test2_1"
+	test.MakeFile(t, root+"/"+path, "data2_x", 0644)
+	updated := time.Now().Add(time.Second * 5)
+	file.GetFs().Chtimes(root+"/"+path, updated, updated)
+
+	// different size => modified
+	test.MakeFile(t, root+"/test1/test1_1", "1", 0644)
+
+	// missing and new files should not be counted as corrupted
+	test.RemoveDir(t, root+"/test3")
+	test.MakeFile(t, root+"/test4/test4_1", "data4_1", 0644)
+
+	result, err := Verify(idx)
+
+	if err != nil {
+		t.Fatal("should be able to verify", err)
+	}
+
+	if result.Counts[Corrupted] != 1 {
+		t.Error("should have 1 corrupted file, not", result.Counts[Corrupted])
+	}
+
+	if (result.Counts[Added] != 1) || (result.Counts[Removed] != 1) || (result.Counts[Modified] != 2) {
+		t.Error("should have 1 added, 1 removed and 2 modified files", result.Counts)
+	}
+}
+
+func TestVerifyMissingRoot(t *testing.T) {
+	idx := IndexForTest(t)
+
+	test.RemoveDir(t, idx.Config().Root())
+
+	if _, err := Verify(idx); err == nil {
+		t.Error("should not be able to verify a missing root")
+	}
+}
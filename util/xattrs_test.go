@@ -0,0 +1,156 @@
+//go:build linux
+// +build linux
+
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/hpresnall/yabrc/config"
+	"github.com/hpresnall/yabrc/index"
+	"github.com/hpresnall/yabrc/test"
+)
+
+func setXattr(t *testing.T, path string, name string, value string) {
+	err := unix.Setxattr(path, name, []byte(value), 0)
+
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		t.Skip("extended attributes are not supported by the temp directory")
+	}
+
+	if err != nil {
+		t.Fatal("cannot set extended attribute", err)
+	}
+}
+
+func TestBuildIndexXattrs(t *testing.T) {
+	root := test.SetupOsFs(t)
+
+	test.MakeFile(t, root+"/file1", "data1", 0644)
+	test.MakeFile(t, root+"/file2", "data2", 0644)
+	setXattr(t, root+"/file1", "user.tag", "red")
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test\nxattrs: true")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	idx, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	e1, _ := idx.Get("file1")
+	e2, _ := idx.Get("file2")
+	x1, exists1 := e1.Xattrs()
+	x2, exists2 := e2.Xattrs()
+
+	if !exists1 || !exists2 || (x1 == x2) {
+		t.Error("extended attributes should be recorded", e1, e2)
+	}
+
+	setXattr(t, root+"/file2", "user.tag", "blue")
+
+	// fast rebuilds reuse the hash but must pick up the new attributes
+	for _, existing := range []*index.Index{nil, idx} {
+		idx2, err := BuildIndex(&cfg, existing)
+
+		if err != nil {
+			t.Fatal("should be able to rebuild Index", err)
+		}
+
+		result, err := CompareIndexes(idx2, idx, false)
+
+		if err != nil {
+			t.Fatal("should be able to compare", err)
+		}
+
+		if d := result.ByCategory(Xattrs); !result.Same() || !result.Counts.MetadataChanged() || (len(d) != 1) || (d[0].Path != "file2") {
+			t.Error("only file2 extended attributes should differ", result.Differences)
+		}
+	}
+
+	cfg.SetXattrNames(true)
+	setXattr(t, root+"/file2", "user.other", "1")
+
+	idx4, err := BuildIndex(&cfg, idx)
+
+	if err != nil {
+		t.Fatal("should be able to rebuild Index", err)
+	}
+
+	e4, _ := idx4.Get("file2")
+
+	if names := e4.XattrNames(); (len(names) != 2) || (names[0] != "user.other") || (names[1] != "user.tag") {
+		t.Error("extended attribute names should be recorded", names)
+	}
+
+	// the existing index did not record names, so none can be listed
+	result, _ := CompareIndexes(idx4, idx, false)
+
+	if d := result.ByCategory(Xattrs); (len(d) != 1) || (d[0].format(time.Now()) != "* 'file2': extended attributes differ") {
+		t.Error("incorrect output without names", result.Differences)
+	}
+
+	if err = unix.Removexattr(root+"/file2", "user.tag"); err != nil {
+		t.Fatal("cannot remove extended attribute", err)
+	}
+
+	idx5, err := BuildIndex(&cfg, idx4)
+
+	if err != nil {
+		t.Fatal("should be able to rebuild Index", err)
+	}
+
+	result, _ = CompareIndexes(idx5, idx4, false)
+
+	if d := result.ByCategory(Xattrs); (len(d) != 1) || (d[0].format(time.Now()) != "* 'file2': extended attributes differ; removed 'user.tag'") {
+		t.Error("output should include removed names", result.Differences)
+	}
+
+	cfg.SetXattrs(false)
+	idx3, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to rebuild Index", err)
+	}
+
+	// extended attributes are not compared unless both indexes recorded them
+	if result, _ := CompareIndexes(idx3, idx, false); len(result.Differences) != 0 {
+		t.Error("indexes without extended attributes should be the same", result.Differences)
+	}
+}
+
+func TestBuildIndexXattrsNFD(t *testing.T) {
+	root := test.SetupOsFs(t)
+
+	// the index stores the NFC name but must read the attributes from the NFD name on disk
+	nfd := norm.NFD.String("café")
+	test.MakeFile(t, root+"/"+nfd, "data1", 0644)
+	setXattr(t, root+"/"+nfd, "user.tag", "red")
+
+	cfg, err := config.FromStringKeepFs(t, "root: "+root+"\nbaseName: test\nxattrs: true")
+
+	if err != nil {
+		t.Fatal("cannot load config", err)
+	}
+
+	idx, err := BuildIndex(&cfg, nil)
+
+	if err != nil {
+		t.Fatal("should be able to build an Index", err)
+	}
+
+	e, exists := idx.Get(norm.NFC.String("café"))
+
+	if _, hasXattrs := e.Xattrs(); !exists || !hasXattrs {
+		t.Error("extended attributes should be recorded", e.String())
+	}
+}